@@ -0,0 +1,395 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTP authentication schemes, used with OPT_HTTPAUTH. They can be combined
+// with "|", the strongest scheme offered by the server will be picked.
+const (
+	AUTH_BASIC = 1 << iota
+	AUTH_DIGEST
+	AUTH_BEARER
+
+	AUTH_ANY = AUTH_BASIC | AUTH_DIGEST | AUTH_BEARER
+)
+
+// Credentials and schemes used to authenticate a request.
+type authConfig struct {
+	// Allowed schemes.
+	schemes int
+
+	username string
+	password string
+	token    string
+
+	// Credentials are only sent to this host, unless unrestricted is
+	// set(OPT_UNRESTRICTED_AUTH).
+	host         string
+	unrestricted bool
+}
+
+// Prepare authentication of a request.
+//
+// Credentials come from OPT_USERPWD, OPT_XOAUTH2_BEARER, the "user:pass@"
+// part of the url(which is removed from the url, so that it will not be sent
+// as basic auth by the standard library) or .netrc.
+func prepareAuth(u *url.URL, options map[int]interface{}) (*authConfig, error) {
+	// OPT_USERPWD is used for signing
+	if _, ok := options[OPT_AWS_SIGV4]; ok {
+		return nil, nil
+	}
+
+	// OPT_UNRESTRICTED_AUTH is validated by prepareRedirect
+	unrestricted, _ := options[OPT_UNRESTRICTED_AUTH].(bool)
+	auth := &authConfig{
+		host:         u.Host,
+		unrestricted: unrestricted,
+	}
+
+	hasUser := false
+	hasPassword := false
+	if u.User != nil {
+		auth.username = u.User.Username()
+		auth.password, hasPassword = u.User.Password()
+		hasUser = true
+		u.User = nil
+	}
+
+	hasUserpwd := false
+	if userpwd_, ok := options[OPT_USERPWD]; ok {
+		userpwd, ok := userpwd_.(string)
+		if !ok {
+			return nil, fmt.Errorf("OPT_USERPWD must be string")
+		}
+
+		if i := strings.Index(userpwd, ":"); i >= 0 {
+			auth.username, auth.password = userpwd[:i], userpwd[i+1:]
+		} else {
+			auth.username, auth.password = userpwd, ""
+		}
+		hasUser = true
+		hasUserpwd = true
+	}
+
+	netrcMode, netrcPath, err := prepareNetrc(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasUserpwd && (netrcMode == NETRC_REQUIRED ||
+		netrcMode == NETRC_OPTIONAL && !hasPassword) {
+		// only the password is looked up if the login is given in the url
+		login := ""
+		if hasUser && !hasPassword {
+			login = auth.username
+		}
+
+		entry, err := lookupNetrc(netrcPath, u.Hostname(), login)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry != nil {
+			auth.username, auth.password = entry.login, entry.password
+			hasUser = true
+		}
+	}
+
+	if token_, ok := options[OPT_XOAUTH2_BEARER]; ok {
+		if auth.token, ok = token_.(string); !ok {
+			return nil, fmt.Errorf("OPT_XOAUTH2_BEARER must be string")
+		}
+	}
+
+	if httpauth_, ok := options[OPT_HTTPAUTH]; ok {
+		if auth.schemes, ok = httpauth_.(int); !ok {
+			return nil, fmt.Errorf("OPT_HTTPAUTH must be int")
+		}
+	} else if auth.token != "" {
+		auth.schemes = AUTH_BEARER
+	} else {
+		auth.schemes = AUTH_BASIC
+	}
+
+	if !hasUser {
+		auth.schemes &^= AUTH_BASIC | AUTH_DIGEST
+	}
+
+	if auth.token == "" {
+		auth.schemes &^= AUTH_BEARER
+	}
+
+	if auth.schemes&AUTH_ANY == 0 {
+		return nil, nil
+	}
+
+	return auth, nil
+}
+
+// Authorization header that can be sent without a challenge, empty if the
+// server must be probed first.
+func (this *authConfig) preemptive() string {
+	switch this.schemes & AUTH_ANY {
+	case AUTH_BASIC:
+		return basicAuth(this.username, this.password)
+	case AUTH_BEARER:
+		return "Bearer " + this.token
+	}
+
+	return ""
+}
+
+// Authorization header in response to the challenges of a 401 response.
+func (this *authConfig) respond(req *http.Request, challenges []authChallenge) (string, error) {
+	// strongest first
+	if this.schemes&AUTH_DIGEST != 0 {
+		for _, c := range challenges {
+			if c.Scheme == "digest" {
+				return digestAuth(req, this.username, this.password, c.Params, "")
+			}
+		}
+	}
+
+	if this.schemes&AUTH_BEARER != 0 {
+		for _, c := range challenges {
+			if c.Scheme == "bearer" {
+				return "Bearer " + this.token, nil
+			}
+		}
+	}
+
+	if this.schemes&AUTH_BASIC != 0 {
+		for _, c := range challenges {
+			if c.Scheme == "basic" {
+				return basicAuth(this.username, this.password), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// Transport which authenticates requests, answering 401 challenges when the
+// scheme can not be chosen in advance.
+type authTransport struct {
+	transport http.RoundTripper
+	auth      *authConfig
+}
+
+func (this *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// do not leak credentials to other hosts
+	if (req.URL.Host != this.auth.host && !this.auth.unrestricted) || req.Header.Get("Authorization") != "" {
+		return this.transport.RoundTrip(req)
+	}
+
+	if authorization := this.auth.preemptive(); authorization != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", authorization)
+		getRequestState(req).credentials = true
+		return this.transport.RoundTrip(req)
+	}
+
+	// The body will be consumed by the first attempt.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return this.transport.RoundTrip(req)
+	}
+
+	res, err := this.transport.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	challenges := parseChallenges(res.Header["Www-Authenticate"])
+	authorization, err := this.auth.respond(req, challenges)
+	if err != nil || authorization == "" {
+		return res, err
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", authorization)
+	getRequestState(retry).credentials = true
+
+	return this.transport.RoundTrip(retry)
+}
+
+func basicAuth(username, password string) string {
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(username, password)
+
+	return req.Header.Get("Authorization")
+}
+
+// An authentication challenge of the WWW-Authenticate header.
+type authChallenge struct {
+	// Lower case scheme name.
+	Scheme string
+	Params map[string]string
+}
+
+// Parse challenges of WWW-Authenticate headers, each header may contain more
+// than one challenge.
+func parseChallenges(headers []string) []authChallenge {
+	var challenges []authChallenge
+	for _, h := range headers {
+		s := h
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+
+			var token string
+			token, s = readToken(s)
+			if token == "" {
+				break
+			}
+
+			rest := strings.TrimLeft(s, " \t")
+			if strings.HasPrefix(rest, "=") && len(challenges) > 0 {
+				// parameter of current challenge
+				var value string
+				value, s = readTokenOrQuoted(strings.TrimLeft(rest[1:], " \t"))
+				challenges[len(challenges)-1].Params[strings.ToLower(token)] = value
+			} else {
+				challenges = append(challenges, authChallenge{
+					Scheme: strings.ToLower(token),
+					Params: make(map[string]string),
+				})
+			}
+		}
+	}
+
+	return challenges
+}
+
+func readToken(s string) (string, string) {
+	i := 0
+	for ; i < len(s); i++ {
+		if strings.IndexByte(" \t,=\"", s[i]) >= 0 {
+			break
+		}
+	}
+
+	return s[:i], s[i:]
+}
+
+func readTokenOrQuoted(s string) (string, string) {
+	if !strings.HasPrefix(s, "\"") {
+		return readToken(s)
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String(), ""
+}
+
+// Build the Authorization header of digest authentication(RFC 7616), only
+// qop "auth" is supported. A random cnonce is generated when empty.
+func digestAuth(req *http.Request, username, password string, params map[string]string, cnonce string) (string, error) {
+	algorithm := params["algorithm"]
+	var h func() hash.Hash
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "", "MD5":
+		h = md5.New
+	case "SHA-256":
+		h = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+
+	hashHex := func(s string) string {
+		d := h()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	var qop string
+	if params["qop"] != "" {
+		for _, v := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(v) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", fmt.Errorf("unsupported digest qop: %s", params["qop"])
+		}
+	}
+
+	if cnonce == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		cnonce = hex.EncodeToString(b)
+	}
+
+	realm, nonce, uri, nc := params["realm"], params["nonce"], req.URL.RequestURI(), "00000001"
+
+	ha1 := hashHex(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		ha1 = hashHex(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := hashHex(req.Method + ":" + uri)
+
+	var response string
+	if qop == "" {
+		response = hashHex(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = hashHex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf("username=%q", username),
+		fmt.Sprintf("realm=%q", realm),
+		fmt.Sprintf("nonce=%q", nonce),
+		fmt.Sprintf("uri=%q", uri),
+	}
+	if algorithm != "" {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+	fields = append(fields, fmt.Sprintf("response=%q", response))
+	if opaque, ok := params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf("opaque=%q", opaque))
+	}
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce))
+	}
+
+	return "Digest " + strings.Join(fields, ", "), nil
+}
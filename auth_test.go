@@ -0,0 +1,194 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A server protected with digest authentication.
+func newDigestServer(algorithm, username, password string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		challenge := `Digest realm="test", qop="auth", nonce="abc123", opaque="xyz", algorithm=` + algorithm
+		params := parseChallenges([]string{r.Header.Get("Authorization")})
+		if len(params) != 1 || params[0].Scheme != "digest" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="test"`)
+			w.Header().Add("WWW-Authenticate", challenge)
+			w.WriteHeader(401)
+			return
+		}
+
+		var h func() hash.Hash = md5.New
+		if algorithm == "SHA-256" {
+			h = sha256.New
+		}
+		hashHex := func(s string) string {
+			d := h()
+			io.WriteString(d, s)
+			return hex.EncodeToString(d.Sum(nil))
+		}
+
+		p := params[0].Params
+		ha1 := hashHex(username + ":test:" + password)
+		ha2 := hashHex(r.Method + ":" + r.URL.RequestURI())
+		expected := hashHex(ha1 + ":abc123:" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+		if p["username"] != username || p["response"] != expected || p["opaque"] != "xyz" {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(401)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+}
+
+func TestDigestAuthRFC2617(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://www.nowhere.org/dir/index.html", nil)
+	authorization, err := digestAuth(req, "Mufasa", "Circle Of Life", map[string]string{
+		"realm":  "testrealm@host.com",
+		"qop":    "auth,auth-int",
+		"nonce":  "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		"opaque": "5ccc069c403ebaf9f0171e9517f40e41",
+	}, "0a4f113b")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(authorization, `response="6629fae49393a05397450978507c4ef1"`) {
+		t.Error("wrong digest response:", authorization)
+	}
+}
+
+func TestParseChallenges(t *testing.T) {
+	challenges := parseChallenges([]string{
+		`Basic realm="a, b", Digest realm="test", qop="auth,auth-int", nonce=abc`,
+		`Bearer`,
+	})
+
+	if len(challenges) != 3 {
+		t.Fatal("wrong challenges:", challenges)
+	}
+
+	if challenges[0].Scheme != "basic" || challenges[0].Params["realm"] != "a, b" {
+		t.Error("wrong basic challenge:", challenges[0])
+	}
+
+	if challenges[1].Scheme != "digest" || challenges[1].Params["qop"] != "auth,auth-int" || challenges[1].Params["nonce"] != "abc" {
+		t.Error("wrong digest challenge:", challenges[1])
+	}
+
+	if challenges[2].Scheme != "bearer" {
+		t.Error("wrong bearer challenge:", challenges[2])
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.WriteHeader(401)
+			return
+		}
+		io.WriteString(w, username+":"+password)
+	}))
+	defer server.Close()
+
+	res, err := NewHttpClient().
+		WithOption(OPT_USERPWD, "user:pass").
+		Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); body != "user:pass" {
+		t.Error("OPT_USERPWD does not work:", res.StatusCode, body)
+	}
+
+	// credentials in url
+	res, err = NewHttpClient().
+		Get(strings.Replace(server.URL, "http://", "http://foo:bar@", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); body != "foo:bar" {
+		t.Error("credentials in url do not work:", res.StatusCode, body)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	res, err := NewHttpClient().
+		WithOption(OPT_XOAUTH2_BEARER, "token").
+		WithOption(OPT_HTTPAUTH, AUTH_BEARER).
+		Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); body != "Bearer token" {
+		t.Error("AUTH_BEARER does not work:", body)
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "SHA-256"} {
+		server := newDigestServer(algorithm, "user", "pass")
+
+		res, err := NewHttpClient().
+			WithOption(OPT_USERPWD, "user:pass").
+			WithOption(OPT_HTTPAUTH, AUTH_DIGEST).
+			Post(server.URL+"/digest?a=b", "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body, _ := res.ToString(); res.StatusCode != 200 || body != "hello" {
+			t.Error("AUTH_DIGEST does not work:", algorithm, res.StatusCode, body)
+		}
+
+		// any should pick digest over basic
+		res, err = NewHttpClient().
+			WithOption(OPT_USERPWD, "user:pass").
+			WithOption(OPT_HTTPAUTH, AUTH_ANY).
+			Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != 200 {
+			t.Error("AUTH_ANY does not work:", algorithm, res.StatusCode)
+		}
+
+		// wrong password
+		res, err = NewHttpClient().
+			WithOption(OPT_USERPWD, "user:wrong").
+			WithOption(OPT_HTTPAUTH, AUTH_DIGEST).
+			Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != 401 {
+			t.Error("wrong password should not pass:", algorithm, res.StatusCode)
+		}
+
+		server.Close()
+	}
+}
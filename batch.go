@@ -0,0 +1,167 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Default number of workers of batch requests.
+const defaultBatchWorkers = 10
+
+// A request of a batch.
+type BatchRequest struct {
+	// Default to GET.
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    io.Reader
+
+	// Options of the request, as WithOptions.
+	Options Map
+}
+
+// Options of a batch.
+type BatchOptions struct {
+	// Number of concurrent requests, default to 10.
+	Workers int
+
+	// Cancel the rest requests once a request fails with an error.
+	FailFast bool
+
+	// Cancel the batch with the context.
+	Context context.Context
+}
+
+// Result of a request of a batch.
+type BatchResult struct {
+	// Index of the request in the batch.
+	Index   int
+	Request *BatchRequest
+
+	// The body has been read, it can be read again from the response.
+	Response *Response
+	Err      error
+}
+
+// Send a request of a batch, the body is read so that the connection can be
+// reused.
+func (this *HttpClient) doBatchRequest(ctx context.Context, index int, request *BatchRequest) *BatchResult {
+	result := &BatchResult{
+		Index:   index,
+		Request: request,
+	}
+
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	method := request.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	c := this.Begin().WithOptions(request.Options)
+	if _, ok := request.Options[OPT_CONTEXT]; !ok {
+		c.WithOption(OPT_CONTEXT, ctx)
+	}
+
+	res, err := c.Do(method, request.URL, request.Headers, request.Body)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	result.Response = res
+	result.Err = err
+
+	return result
+}
+
+// Send requests concurrently, results are sent to the channel as they
+// complete. The channel is closed when all requests are done, requests which
+// are canceled before they are sent have the error of the context.
+func (this *HttpClient) Parallel(requests []*BatchRequest, options *BatchOptions) <-chan *BatchResult {
+	if options == nil {
+		options = &BatchOptions{}
+	}
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+	if workers > len(requests) {
+		workers = len(requests)
+	}
+
+	parent := options.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+
+	// results never block the workers
+	results := make(chan *BatchResult, len(requests))
+	jobs := make(chan int)
+
+	go func() {
+		defer close(jobs)
+		for i := range requests {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				for ; i < len(requests); i++ {
+					results <- &BatchResult{
+						Index:   i,
+						Request: requests[i],
+						Err:     ctx.Err(),
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := this.doBatchRequest(ctx, i, requests[i])
+				if options.FailFast && result.Err != nil {
+					cancel()
+				}
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(results)
+	}()
+
+	return results
+}
+
+// Send requests concurrently, and get results in the order of requests.
+func (this *HttpClient) Batch(requests []*BatchRequest, options *BatchOptions) []*BatchResult {
+	results := make([]*BatchResult, len(requests))
+	for result := range this.Parallel(requests, options) {
+		results[result.Index] = result
+	}
+
+	return results
+}
@@ -0,0 +1,137 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	var concurrent, maxConcurrent int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&concurrent, 1)
+		defer atomic.AddInt32(&concurrent, -1)
+		for {
+			max := atomic.LoadInt32(&maxConcurrent)
+			if n <= max || atomic.CompareAndSwapInt32(&maxConcurrent, max, n) {
+				break
+			}
+		}
+
+		// later requests complete first
+		i, _ := strconv.Atoi(r.URL.Query().Get("i"))
+		time.Sleep(time.Duration(10-i) * 5 * time.Millisecond)
+
+		io.WriteString(w, r.Method+" "+r.URL.Query().Get("i")+" "+r.Header.Get("X-Test"))
+	}))
+	defer server.Close()
+
+	var requests []*BatchRequest
+	for i := 0; i < 10; i++ {
+		requests = append(requests, &BatchRequest{
+			URL:     server.URL + "?i=" + strconv.Itoa(i),
+			Headers: map[string]string{"X-Test": "header"},
+		})
+	}
+	requests[1].Method = "POST"
+
+	c := NewHttpClient()
+	results := c.Batch(requests, &BatchOptions{Workers: 3})
+
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+
+		method := "GET"
+		if i == 1 {
+			method = "POST"
+		}
+
+		body, _ := result.Response.ToString()
+		if result.Index != i || body != method+" "+strconv.Itoa(i)+" header" {
+			t.Error("wrong result:", i, body)
+		}
+	}
+
+	if max := atomic.LoadInt32(&maxConcurrent); max > 3 {
+		t.Error("too many concurrent requests:", max)
+	}
+
+	// streaming
+	count := 0
+	for result := range c.Parallel(requests, nil) {
+		if result.Err != nil || result.Request != requests[result.Index] {
+			t.Error("wrong result:", result.Index, result.Err)
+		}
+		count++
+	}
+
+	if count != len(requests) {
+		t.Error("missing results:", count)
+	}
+}
+
+func TestBatchFailFast(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	requests := []*BatchRequest{
+		{URL: "http://invalid host/"},
+	}
+	for i := 0; i < 10; i++ {
+		requests = append(requests, &BatchRequest{URL: server.URL})
+	}
+
+	results := NewHttpClient().Batch(requests, &BatchOptions{
+		Workers:  1,
+		FailFast: true,
+	})
+
+	if results[0].Err == nil {
+		t.Error("first request should fail")
+	}
+
+	for _, result := range results[1:] {
+		if result.Err != context.Canceled {
+			t.Error("rest requests should be canceled:", result.Err)
+		}
+	}
+
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Error("canceled requests should not be sent")
+	}
+
+	// canceled by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	results = NewHttpClient().Batch(requests[1:], &BatchOptions{
+		Workers: 1,
+		Context: ctx,
+	})
+
+	canceled := 0
+	for _, result := range results {
+		if result.Err != nil && (result.Err == context.DeadlineExceeded || strings.Contains(result.Err.Error(), "deadline")) {
+			canceled++
+		}
+	}
+
+	if canceled < 8 {
+		t.Error("requests should be canceled with the context:", canceled)
+	}
+}
@@ -0,0 +1,539 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache status of a response, see Response.CacheStatus.
+const (
+	// The response is from the server.
+	CACHE_MISS = iota
+
+	// The response is served from the cache without contacting the server.
+	CACHE_HIT
+
+	// The cached response is validated by the server(304 Not Modified).
+	CACHE_REVALIDATED
+)
+
+// Status codes which are cacheable by default(heuristically).
+var heuristicCacheableStatus = map[int]bool{
+	200: true,
+	203: true,
+	204: true,
+	300: true,
+	301: true,
+	308: true,
+	404: true,
+	405: true,
+	410: true,
+	414: true,
+	501: true,
+}
+
+// Max heuristic freshness lifetime.
+const maxHeuristicLifetime = 24 * time.Hour
+
+// Max size of a cached body, larger responses are passed through without
+// being stored.
+const maxCacheBodySize = 10 << 20
+
+// A cached response.
+type cacheEntry struct {
+	RequestTime  time.Time `json:"requestTime"`
+	ResponseTime time.Time `json:"responseTime"`
+
+	// Request headers selected by Vary.
+	Vary http.Header `json:"vary"`
+
+	Status     string      `json:"status"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// Build a response of the request from the entry.
+func (this *cacheEntry) response(req *http.Request, now time.Time) *http.Response {
+	header := make(http.Header, len(this.Header))
+	for k, v := range this.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set("Age", strconv.FormatInt(int64(this.age(now)/time.Second), 10))
+
+	res := &http.Response{
+		Status:     this.Status,
+		StatusCode: this.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Request:    req,
+	}
+
+	if req.Method == "HEAD" {
+		res.Body = http.NoBody
+		res.ContentLength, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	} else {
+		res.Body = ioutil.NopCloser(bytes.NewReader(this.Body))
+		res.ContentLength = int64(len(this.Body))
+	}
+
+	return res
+}
+
+// Current age of RFC 9111 section 4.2.3.
+func (this *cacheEntry) age(now time.Time) time.Duration {
+	date, err := http.ParseTime(this.Header.Get("Date"))
+	if err != nil {
+		date = this.ResponseTime
+	}
+
+	apparentAge := this.ResponseTime.Sub(date)
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+
+	ageValue, _ := strconv.ParseInt(this.Header.Get("Age"), 10, 64)
+	correctedAge := time.Duration(ageValue)*time.Second + this.ResponseTime.Sub(this.RequestTime)
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+
+	return correctedAge + now.Sub(this.ResponseTime)
+}
+
+// Freshness lifetime of RFC 9111 section 4.2.1.
+func (this *cacheEntry) lifetime() time.Duration {
+	cc := parseCacheControl(this.Header)
+	if sMaxAge, ok := cc.seconds("s-maxage"); ok {
+		return sMaxAge
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+
+	date, err := http.ParseTime(this.Header.Get("Date"))
+	if err != nil {
+		date = this.ResponseTime
+	}
+
+	if expires := this.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid dates mean expired
+			return 0
+		}
+		return t.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(this.Header.Get("Last-Modified")); err == nil &&
+		heuristicCacheableStatus[this.StatusCode] {
+		lifetime := date.Sub(lastModified) / 10
+		if lifetime > maxHeuristicLifetime {
+			lifetime = maxHeuristicLifetime
+		}
+		return lifetime
+	}
+
+	return 0
+}
+
+// Can the entry be used for the request without validation?
+func (this *cacheEntry) fresh(reqCC cacheControl, now time.Time) bool {
+	resCC := parseCacheControl(this.Header)
+	if reqCC.has("no-cache") || resCC.has("no-cache") {
+		return false
+	}
+
+	age := this.age(now)
+	lifetime := this.lifetime()
+
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+
+	if age < lifetime {
+		return true
+	}
+
+	// stale responses
+	if !reqCC.has("max-stale") || resCC.has("must-revalidate") {
+		return false
+	}
+
+	maxStale, ok := reqCC.seconds("max-stale")
+
+	return !ok || age-lifetime <= maxStale
+}
+
+// Does the request select the entry with Vary?
+func (this *cacheEntry) matchVary(req *http.Request) bool {
+	for name, values := range this.Vary {
+		if strings.Join(req.Header[name], ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Update the entry with a 304 response, as RFC 9111 section 4.3.4.
+func (this *cacheEntry) update(res *http.Response, requestTime, responseTime time.Time) {
+	for k, v := range res.Header {
+		if k == "Content-Length" || k == "Transfer-Encoding" {
+			continue
+		}
+		this.Header[k] = v
+	}
+
+	this.RequestTime = requestTime
+	this.ResponseTime = responseTime
+}
+
+// Directives of Cache-Control.
+type cacheControl map[string]string
+
+// Parse Cache-Control of the header, "Pragma: no-cache" is respected when
+// there's no Cache-Control.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := make(cacheControl)
+	values := header["Cache-Control"]
+	if len(values) == 0 && strings.EqualFold(strings.TrimSpace(header.Get("Pragma")), "no-cache") {
+		cc["no-cache"] = ""
+	}
+
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+
+			name, arg := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+
+	return cc
+}
+
+func (this cacheControl) has(name string) bool {
+	_, ok := this[name]
+	return ok
+}
+
+// Value of a delta-seconds directive.
+func (this cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := this[name]
+	if !ok || arg == "" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		// invalid values are treated as 0
+		return 0, true
+	}
+
+	return time.Duration(n) * time.Second, true
+}
+
+// A RoundTripper caches responses of GET and HEAD requests, as a shared
+// cache of RFC 9111(the storage can be shared by clients).
+type cacheTransport struct {
+	transport http.RoundTripper
+	storage   CacheStorage
+}
+
+// Key of the cached response of the request.
+func cacheKey(method string, u *url.URL) string {
+	u2 := *u
+	u2.Fragment = ""
+	u2.User = nil
+
+	return method + " " + u2.String()
+}
+
+// Can the response of the request be served from the cache? Requests with
+// their own conditional headers or ranges are passed through.
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	for _, name := range []string{"Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if req.Header.Get(name) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Safe methods do not invalidate the cache.
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS" || method == "TRACE"
+}
+
+func (this *cacheTransport) load(req *http.Request) *cacheEntry {
+	data, ok := this.storage.Get(cacheKey(req.Method, req.URL))
+	if !ok {
+		return nil
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil || !entry.matchVary(req) {
+		return nil
+	}
+
+	return entry
+}
+
+func (this *cacheTransport) store(req *http.Request, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	this.storage.Set(cacheKey(req.Method, req.URL), data)
+}
+
+// Delete cached responses of the url.
+func (this *cacheTransport) invalidate(u *url.URL) {
+	this.storage.Delete(cacheKey("GET", u))
+	this.storage.Delete(cacheKey("HEAD", u))
+}
+
+// Invalidate cached responses after an unsafe request, as RFC 9111 section
+// 4.4.
+func (this *cacheTransport) invalidateAfter(req *http.Request, res *http.Response) {
+	if isSafeMethod(req.Method) || res.StatusCode >= 400 {
+		return
+	}
+
+	this.invalidate(req.URL)
+	for _, name := range []string{"Location", "Content-Location"} {
+		if v := res.Header.Get(name); v != "" {
+			// only the same origin
+			if u, err := req.URL.Parse(v); err == nil && u.Host == req.URL.Host {
+				this.invalidate(u)
+			}
+		}
+	}
+}
+
+// Build a cache entry if the response can be stored.
+//
+// The storage can be shared by clients, so private responses are never
+// stored, and responses to requests with credentials are stored only if they
+// are public, as a shared cache(RFC 9111 section 3.5).
+func newCacheEntry(req *http.Request, res *http.Response, credentials bool, requestTime, responseTime time.Time) *cacheEntry {
+	cc := parseCacheControl(res.Header)
+	if cc.has("no-store") || cc.has("private") || parseCacheControl(req.Header).has("no-store") {
+		return nil
+	}
+
+	if res.ContentLength > maxCacheBodySize {
+		return nil
+	}
+
+	if credentials && !cc.has("public") && !cc.has("s-maxage") {
+		return nil
+	}
+
+	entry := &cacheEntry{
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Vary:         make(http.Header),
+		Status:       res.Status,
+		StatusCode:   res.StatusCode,
+		Header:       res.Header.Clone(),
+	}
+
+	explicit := cc.has("max-age") || res.Header.Get("Expires") != ""
+	switch {
+	case heuristicCacheableStatus[res.StatusCode]:
+	case (res.StatusCode == 302 || res.StatusCode == 307) && explicit:
+	default:
+		return nil
+	}
+
+	for _, value := range res.Header["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				name = http.CanonicalHeaderKey(name)
+				entry.Vary[name] = req.Header[name]
+			}
+		}
+	}
+
+	// nothing to reuse
+	if !explicit && entry.lifetime() <= 0 && res.Header.Get("ETag") == "" && res.Header.Get("Last-Modified") == "" {
+		return nil
+	}
+
+	return entry
+}
+
+// A response body which stores the entry when it's read to the end. Bodies
+// larger than maxCacheBodySize are not buffered or stored.
+type cachingBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	store func(body []byte)
+}
+
+func (this *cachingBody) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+	if this.store != nil {
+		if this.buf.Len()+n > maxCacheBodySize {
+			this.store = nil
+			this.buf = bytes.Buffer{}
+		} else {
+			this.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && this.store != nil {
+		this.store(this.buf.Bytes())
+		this.store = nil
+	}
+
+	return n, err
+}
+
+// The response of only-if-cached requests which can not be served from the
+// cache.
+func gatewayTimeoutResponse(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: 504,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// Implement http.RoundTripper.
+func (this *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state := getRequestState(req)
+	state.cacheStatus = CACHE_MISS
+	state.credentials = false
+
+	reqCC := parseCacheControl(req.Header)
+	if !cacheableRequest(req) || reqCC.has("no-store") {
+		res, err := this.transport.RoundTrip(req)
+		if err == nil {
+			this.invalidateAfter(req, res)
+		}
+		return res, err
+	}
+
+	now := time.Now()
+	entry := this.load(req)
+	if entry != nil && entry.fresh(reqCC, now) {
+		state.cacheStatus = CACHE_HIT
+		return entry.response(req, now), nil
+	}
+
+	if reqCC.has("only-if-cached") {
+		return gatewayTimeoutResponse(req), nil
+	}
+
+	outreq := req
+	if entry != nil {
+		etag := entry.Header.Get("ETag")
+		lastModified := entry.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			outreq = req.Clone(req.Context())
+			if etag != "" {
+				outreq.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				outreq.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+
+	requestTime := now
+	res, err := this.transport.RoundTrip(outreq)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	if outreq != req && res.StatusCode == 304 {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		entry.update(res, requestTime, responseTime)
+		this.store(req, entry)
+		state.cacheStatus = CACHE_REVALIDATED
+
+		return entry.response(req, responseTime), nil
+	}
+
+	credentials := state.credentials || req.Header.Get("Authorization") != "" || req.URL.User != nil
+	newEntry := newCacheEntry(req, res, credentials, requestTime, responseTime)
+	if newEntry == nil {
+		if entry != nil {
+			this.invalidate(req.URL)
+		}
+		return res, nil
+	}
+
+	if req.Method == "HEAD" {
+		this.store(req, newEntry)
+		return res, nil
+	}
+
+	res.Body = &cachingBody{
+		ReadCloser: res.Body,
+		store: func(body []byte) {
+			newEntry.Body = append([]byte(nil), body...)
+			this.store(req, newEntry)
+		},
+	}
+
+	return res, nil
+}
+
+// Prepare the cache storage with OPT_CACHE.
+func prepareCache(options map[int]interface{}) (CacheStorage, error) {
+	cache_, ok := options[OPT_CACHE]
+	if !ok || cache_ == nil {
+		return nil, nil
+	}
+
+	cache, ok := cache_.(CacheStorage)
+	if !ok {
+		return nil, fmt.Errorf("OPT_CACHE must be httpclient.CacheStorage")
+	}
+
+	return cache, nil
+}
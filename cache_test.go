@@ -0,0 +1,300 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(http.Header{
+		"Cache-Control": {`max-age=60, no-cache="Set-Cookie"`, "Must-Revalidate, max-stale"},
+	})
+
+	if v, ok := cc.seconds("max-age"); !ok || v != time.Minute {
+		t.Error("wrong max-age:", v)
+	}
+
+	if !cc.has("no-cache") || !cc.has("must-revalidate") || !cc.has("max-stale") {
+		t.Error("wrong directives:", cc)
+	}
+
+	if _, ok := cc.seconds("max-stale"); ok {
+		t.Error("max-stale has no value")
+	}
+
+	if !parseCacheControl(http.Header{"Pragma": {"no-cache"}}).has("no-cache") {
+		t.Error("Pragma should be respected")
+	}
+}
+
+func TestCacheFreshness(t *testing.T) {
+	now := time.Now()
+	date := now.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	cases := []struct {
+		header   http.Header
+		lifetime time.Duration
+	}{
+		{http.Header{"Cache-Control": {"max-age=60"}, "Expires": {"0"}}, time.Minute},
+		{http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, 2 * time.Minute},
+		{http.Header{"Date": {date}, "Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)}}, 2 * time.Hour},
+		{http.Header{"Expires": {"0"}}, 0},
+		{http.Header{"Date": {date}, "Last-Modified": {now.Add(-11 * time.Hour).UTC().Format(http.TimeFormat)}}, time.Hour},
+		{http.Header{}, 0},
+	}
+
+	for _, v := range cases {
+		entry := &cacheEntry{
+			StatusCode:   200,
+			Header:       v.header,
+			RequestTime:  now,
+			ResponseTime: now,
+		}
+		if lifetime := entry.lifetime(); lifetime != v.lifetime {
+			t.Error("wrong lifetime:", v.header, lifetime)
+		}
+	}
+
+	entry := &cacheEntry{
+		StatusCode:   200,
+		Header:       http.Header{"Cache-Control": {"max-age=60"}, "Age": {"50"}},
+		RequestTime:  now,
+		ResponseTime: now,
+	}
+
+	if age := entry.age(now.Add(5 * time.Second)); age != 55*time.Second {
+		t.Error("wrong age:", age)
+	}
+
+	later := now.Add(20 * time.Second)
+	freshness := map[string]bool{
+		"":                       false,
+		"max-stale":              true,
+		"max-stale=5":            false,
+		"max-stale=20":           true,
+		"no-cache, max-stale":    false,
+		"max-age=100, max-stale": true,
+	}
+	for cc, expected := range freshness {
+		reqCC := parseCacheControl(http.Header{"Cache-Control": {cc}})
+		if fresh := entry.fresh(reqCC, later); fresh != expected {
+			t.Errorf("wrong freshness with %q: %v", cc, fresh)
+		}
+	}
+
+	if entry.fresh(parseCacheControl(http.Header{"Cache-Control": {"min-fresh=11"}}), now) {
+		t.Error("min-fresh is not respected")
+	}
+}
+
+func TestCache(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(304)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			fmt.Fprint(w, r.Header.Get("Accept-Language"))
+			return
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+
+		fmt.Fprint(w, "response ", n)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []CacheStorage{NewMemoryCache(0), NewDiskCache(dir)} {
+		atomic.StoreInt32(&hits, 0)
+		c := NewHttpClient().Defaults(Map{
+			OPT_CACHE: storage,
+		})
+
+		get := func(path string, expectedBody string, expectedStatus int, headers ...string) {
+			req := c
+			if len(headers) == 2 {
+				req = c.WithHeader(headers[0], headers[1])
+			}
+
+			res, err := req.Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := res.ToString()
+			if body != expectedBody || res.CacheStatus != expectedStatus {
+				t.Errorf("unexpected response of %s: %q %d", path, body, res.CacheStatus)
+			}
+		}
+
+		get("/fresh", "response 1", CACHE_MISS)
+		get("/fresh", "response 1", CACHE_HIT)
+		get("/fresh", "response 2", CACHE_MISS, "Cache-Control", "no-cache")
+		get("/fresh", "response 2", CACHE_HIT)
+
+		get("/etag", "response 3", CACHE_MISS)
+		get("/etag", "response 3", CACHE_REVALIDATED)
+
+		get("/vary", "en", CACHE_MISS, "Accept-Language", "en")
+		get("/vary", "fr", CACHE_MISS, "Accept-Language", "fr")
+		get("/vary", "fr", CACHE_HIT, "Accept-Language", "fr")
+
+		get("/no-store", "response 7", CACHE_MISS)
+		get("/no-store", "response 8", CACHE_MISS)
+
+		// invalidated by unsafe methods
+		res, err := c.Post(server.URL+"/fresh", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.ToString()
+		get("/fresh", "response 10", CACHE_MISS)
+
+		// only-if-cached
+		res, err = c.WithHeader("Cache-Control", "only-if-cached").Get(server.URL + "/missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 504 || atomic.LoadInt32(&hits) != 10 {
+			t.Error("only-if-cached should not contact the server")
+		}
+	}
+}
+
+func TestCacheCredentials(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else if r.URL.Path == "/private-response" {
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+
+		fmt.Fprint(w, r.Header.Get("Authorization") != "", " ", n)
+	}))
+	defer server.Close()
+
+	storage := NewMemoryCache(0)
+	anonymous := NewHttpClient().Defaults(Map{
+		OPT_CACHE: storage,
+	})
+	authenticated := NewHttpClient().Defaults(Map{
+		OPT_CACHE:   storage,
+		OPT_USERPWD: "user:password",
+	})
+
+	get := func(c *HttpClient, path string, expectedBody string, expectedStatus int) {
+		res, err := c.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := res.ToString()
+		if body != expectedBody || res.CacheStatus != expectedStatus {
+			t.Errorf("unexpected response of %s: %q %d", path, body, res.CacheStatus)
+		}
+	}
+
+	get(authenticated, "/private", "true 1", CACHE_MISS)
+	get(anonymous, "/private", "false 2", CACHE_MISS)
+	get(anonymous.WithHeader("Authorization", "Bearer token"), "/header", "true 3", CACHE_MISS)
+	get(anonymous, "/header", "false 4", CACHE_MISS)
+
+	// public responses can be shared
+	get(authenticated, "/public", "true 5", CACHE_MISS)
+	get(authenticated, "/public", "true 5", CACHE_HIT)
+
+	// private responses are never stored in the shared storage
+	get(anonymous, "/private-response", "false 6", CACHE_MISS)
+	get(anonymous, "/private-response", "false 7", CACHE_MISS)
+}
+
+func TestCacheMaxBodySize(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/length" {
+			w.Header().Set("Content-Length", strconv.Itoa(maxCacheBodySize+1))
+		}
+		w.Write(make([]byte, maxCacheBodySize+1))
+	}))
+	defer server.Close()
+
+	storage := NewMemoryCache(0)
+	c := NewHttpClient().Defaults(Map{
+		OPT_CACHE: storage,
+	})
+
+	for _, path := range []string{"/length", "/chunked"} {
+		for i := 0; i < 2; i++ {
+			res, err := c.Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := res.ReadAll()
+			if len(body) != maxCacheBodySize+1 || res.CacheStatus != CACHE_MISS {
+				t.Errorf("unexpected response of %s: %d %d", path, len(body), res.CacheStatus)
+			}
+		}
+	}
+
+	if hits != 4 || storage.Size() != 0 {
+		t.Error("large bodies should not be cached:", hits, storage.Size())
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := NewMemoryCache(10)
+	cache.Set("a", []byte("12345"))
+	cache.Set("b", []byte("12345"))
+	cache.Get("a")
+	cache.Set("c", []byte("1"))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("least recently used entry should be evicted")
+	}
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("recently used entry should be kept")
+	}
+
+	cache.Set("d", []byte("12345678901"))
+	if _, ok := cache.Get("d"); ok || cache.Size() != 6 {
+		t.Error("large entry should be dropped")
+	}
+
+	cache.Delete("a")
+	if cache.Size() != 1 {
+		t.Error("wrong size:", cache.Size())
+	}
+}
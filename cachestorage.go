@@ -0,0 +1,174 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage of cached responses, see OPT_CACHE. Implementations must be safe
+// for concurrent use.
+type CacheStorage interface {
+	// Get the cached data of the key.
+	Get(key string) ([]byte, bool)
+
+	// Store data of the key, the storage may drop it(e.g. it's too large).
+	Set(key string, data []byte)
+
+	// Delete data of the key.
+	Delete(key string)
+}
+
+// In-memory cache storage, the least recently used entries are evicted when
+// the size limit is reached.
+type MemoryCache struct {
+	lock sync.Mutex
+
+	maxSize int64
+	size    int64
+
+	// Most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// Create an in-memory cache storage with the max size in bytes, zero means
+// no limit.
+func NewMemoryCache(maxSize int64) *MemoryCache {
+	return &MemoryCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Implement CacheStorage.
+func (this *MemoryCache) Get(key string) ([]byte, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	e, ok := this.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	this.lru.MoveToFront(e)
+
+	return e.Value.(*memoryCacheEntry).data, true
+}
+
+// Implement CacheStorage.
+func (this *MemoryCache) Set(key string, data []byte) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.delete(key)
+
+	if this.maxSize > 0 && int64(len(data)) > this.maxSize {
+		return
+	}
+
+	this.entries[key] = this.lru.PushFront(&memoryCacheEntry{
+		key:  key,
+		data: data,
+	})
+	this.size += int64(len(data))
+
+	for this.maxSize > 0 && this.size > this.maxSize {
+		this.delete(this.lru.Back().Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Implement CacheStorage.
+func (this *MemoryCache) Delete(key string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.delete(key)
+}
+
+// Must be called with the lock.
+func (this *MemoryCache) delete(key string) {
+	if e, ok := this.entries[key]; ok {
+		this.size -= int64(len(e.Value.(*memoryCacheEntry).data))
+		this.lru.Remove(e)
+		delete(this.entries, key)
+	}
+}
+
+// Number of bytes stored.
+func (this *MemoryCache) Size() int64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.size
+}
+
+// On-disk cache storage, every entry is a file in the directory.
+type DiskCache struct {
+	dir string
+}
+
+// Create an on-disk cache storage, the directory is created when needed.
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{
+		dir: dir,
+	}
+}
+
+// Path of the file of the key.
+func (this *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(this.dir, hex.EncodeToString(sum[:]))
+}
+
+// Implement CacheStorage.
+func (this *DiskCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(this.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Implement CacheStorage, errors are ignored as the entry is just not
+// cached.
+func (this *DiskCache) Set(key string, data []byte) {
+	if err := os.MkdirAll(this.dir, 0700); err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(this.dir, ".tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		// replaced atomically, readers never see a partial entry
+		os.Rename(f.Name(), this.path(key))
+	}
+}
+
+// Implement CacheStorage.
+func (this *DiskCache) Delete(key string) {
+	os.Remove(this.path(key))
+}
@@ -0,0 +1,373 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// States of a circuit.
+const (
+	// Requests are sent.
+	CIRCUIT_CLOSED = iota
+
+	// Requests fail fast with ERR_CIRCUIT_OPEN.
+	CIRCUIT_OPEN
+
+	// Probe requests are sent to check whether the host recovers.
+	CIRCUIT_HALF_OPEN
+)
+
+// Default settings of CircuitBreaker.
+const (
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitMinRequests      = 10
+	defaultCircuitWindow           = time.Minute
+	defaultCircuitOpenTimeout      = 30 * time.Second
+)
+
+// A circuit breaker per host, see OPT_CIRCUIT_BREAKER. It can be shared by
+// clients. Fields should be set before it's used, zero values mean defaults.
+type CircuitBreaker struct {
+	// Trip after the number of consecutive failures, default to 5. Negative
+	// to disable.
+	FailureThreshold int
+
+	// Trip when the ratio of failures in the window reaches it, zero to
+	// disable.
+	FailureRatio float64
+
+	// Minimum number of requests in the window to check FailureRatio, default
+	// to 10.
+	MinRequests int
+
+	// The window to count requests for FailureRatio, default to 1 minute.
+	Window time.Duration
+
+	// How long the circuit stays open before probing, default to 30 seconds.
+	OpenTimeout time.Duration
+
+	// Number of successful probe requests to close the circuit, default to
+	// 1. Other requests fail fast while probing.
+	HalfOpenRequests int
+
+	// Status codes counted as failures, default to 5xx. Transport errors are
+	// always failures.
+	FailureStatus []int
+
+	// Key of the circuit of a request, the host(with port) by default.
+	KeyFunc func(req *http.Request) string
+
+	// Called when the state of a circuit changes.
+	OnStateChange func(key string, from int, to int)
+
+	lock     sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state int
+
+	consecutiveFailures int
+
+	// Counters of the window.
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	openedAt time.Time
+
+	// Probe requests of the half open state.
+	probes    int
+	successes int
+}
+
+type circuitStateChange struct {
+	key      string
+	from, to int
+}
+
+// Create a circuit breaker with default settings.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		circuits: make(map[string]*circuit),
+	}
+}
+
+func (this *CircuitBreaker) key(req *http.Request) string {
+	if this.KeyFunc != nil {
+		return this.KeyFunc(req)
+	}
+
+	return req.URL.Host
+}
+
+// State of the circuit of the key.
+func (this *CircuitBreaker) State(key string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if c, ok := this.circuits[key]; ok {
+		return c.state
+	}
+
+	return CIRCUIT_CLOSED
+}
+
+// Reset the circuit of the key to closed.
+func (this *CircuitBreaker) Reset(key string) {
+	this.lock.Lock()
+	c, ok := this.circuits[key]
+	delete(this.circuits, key)
+	this.lock.Unlock()
+
+	if ok && c.state != CIRCUIT_CLOSED {
+		this.notify([]circuitStateChange{{key, c.state, CIRCUIT_CLOSED}})
+	}
+}
+
+func (this *CircuitBreaker) notify(changes []circuitStateChange) {
+	if this.OnStateChange == nil {
+		return
+	}
+
+	for _, change := range changes {
+		this.OnStateChange(change.key, change.from, change.to)
+	}
+}
+
+// Must be called with the lock.
+func (this *CircuitBreaker) setState(key string, c *circuit, state int, now time.Time, changes *[]circuitStateChange) {
+	*changes = append(*changes, circuitStateChange{key, c.state, state})
+	c.state = state
+
+	switch state {
+	case CIRCUIT_OPEN:
+		c.openedAt = now
+	case CIRCUIT_HALF_OPEN:
+		c.probes = 0
+		c.successes = 0
+	case CIRCUIT_CLOSED:
+		c.consecutiveFailures = 0
+		c.windowStart = now
+		c.requests = 0
+		c.failures = 0
+	}
+}
+
+// Check whether a request of the key can be sent.
+func (this *CircuitBreaker) allow(key string, now time.Time) error {
+	var changes []circuitStateChange
+	defer func() {
+		this.notify(changes)
+	}()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.circuits == nil {
+		this.circuits = make(map[string]*circuit)
+	}
+
+	c, ok := this.circuits[key]
+	if !ok {
+		c = &circuit{
+			windowStart: now,
+		}
+		this.circuits[key] = c
+	}
+
+	openTimeout := this.OpenTimeout
+	if openTimeout <= 0 {
+		openTimeout = defaultCircuitOpenTimeout
+	}
+
+	if c.state == CIRCUIT_OPEN && now.Sub(c.openedAt) >= openTimeout {
+		this.setState(key, c, CIRCUIT_HALF_OPEN, now, &changes)
+	}
+
+	halfOpenRequests := this.HalfOpenRequests
+	if halfOpenRequests <= 0 {
+		halfOpenRequests = 1
+	}
+
+	switch c.state {
+	case CIRCUIT_OPEN:
+		return &Error{
+			Code:    ERR_CIRCUIT_OPEN,
+			Message: fmt.Sprintf("circuit of %s is open", key),
+		}
+	case CIRCUIT_HALF_OPEN:
+		if c.probes >= halfOpenRequests {
+			return &Error{
+				Code:    ERR_CIRCUIT_OPEN,
+				Message: fmt.Sprintf("circuit of %s is half open", key),
+			}
+		}
+		c.probes++
+	}
+
+	return nil
+}
+
+// Record the result of a request of the key.
+func (this *CircuitBreaker) record(key string, failed bool, now time.Time) {
+	var changes []circuitStateChange
+	defer func() {
+		this.notify(changes)
+	}()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	c, ok := this.circuits[key]
+	if !ok {
+		return
+	}
+
+	switch c.state {
+	case CIRCUIT_HALF_OPEN:
+		if failed {
+			this.setState(key, c, CIRCUIT_OPEN, now, &changes)
+			return
+		}
+
+		c.successes++
+		halfOpenRequests := this.HalfOpenRequests
+		if halfOpenRequests <= 0 {
+			halfOpenRequests = 1
+		}
+		if c.successes >= halfOpenRequests {
+			this.setState(key, c, CIRCUIT_CLOSED, now, &changes)
+		}
+	case CIRCUIT_CLOSED:
+		window := this.Window
+		if window <= 0 {
+			window = defaultCircuitWindow
+		}
+		if now.Sub(c.windowStart) > window {
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+
+		c.requests++
+		if failed {
+			c.consecutiveFailures++
+			c.failures++
+		} else {
+			c.consecutiveFailures = 0
+		}
+
+		if this.shouldTrip(c) {
+			this.setState(key, c, CIRCUIT_OPEN, now, &changes)
+		}
+	}
+}
+
+// Release the probe of a request which is not completed, the host is not
+// checked.
+func (this *CircuitBreaker) release(key string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if c, ok := this.circuits[key]; ok && c.state == CIRCUIT_HALF_OPEN && c.probes > 0 {
+		c.probes--
+	}
+}
+
+// Must be called with the lock.
+func (this *CircuitBreaker) shouldTrip(c *circuit) bool {
+	threshold := this.FailureThreshold
+	if threshold == 0 {
+		threshold = defaultCircuitFailureThreshold
+	}
+
+	if threshold > 0 && c.consecutiveFailures >= threshold {
+		return true
+	}
+
+	minRequests := this.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultCircuitMinRequests
+	}
+
+	return this.FailureRatio > 0 && c.requests >= minRequests &&
+		float64(c.failures)/float64(c.requests) >= this.FailureRatio
+}
+
+// Is the request abandoned before it's completed(canceled by the caller, or
+// the context is done while waiting for the rate limiter)? The host is not to
+// blame.
+func isAbandoned(err error) bool {
+	var waitErr *rateLimitWaitError
+
+	return errors.Is(err, context.Canceled) || errors.As(err, &waitErr)
+}
+
+// Is the result a failure?
+func (this *CircuitBreaker) isFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	if this.FailureStatus == nil {
+		return res.StatusCode >= 500
+	}
+
+	for _, status := range this.FailureStatus {
+		if res.StatusCode == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A RoundTripper fails fast when the circuit of the host is open.
+type circuitBreakerTransport struct {
+	transport http.RoundTripper
+	breaker   *CircuitBreaker
+}
+
+// Implement http.RoundTripper.
+func (this *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := this.breaker.key(req)
+	if err := this.breaker.allow(key, time.Now()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	res, err := this.transport.RoundTrip(req)
+	if err != nil && isAbandoned(err) {
+		this.breaker.release(key)
+		return res, err
+	}
+
+	this.breaker.record(key, this.breaker.isFailure(res, err), time.Now())
+
+	return res, err
+}
+
+// Prepare the circuit breaker with OPT_CIRCUIT_BREAKER.
+func prepareCircuitBreaker(options map[int]interface{}) (*CircuitBreaker, error) {
+	breaker_, ok := options[OPT_CIRCUIT_BREAKER]
+	if !ok || breaker_ == nil {
+		return nil, nil
+	}
+
+	breaker, ok := breaker_.(*CircuitBreaker)
+	if !ok {
+		return nil, fmt.Errorf("OPT_CIRCUIT_BREAKER must be *httpclient.CircuitBreaker")
+	}
+
+	return breaker, nil
+}
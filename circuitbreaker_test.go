@@ -0,0 +1,227 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer server.Close()
+
+	var changes []string
+	breaker := NewCircuitBreaker()
+	breaker.FailureThreshold = 3
+	breaker.OpenTimeout = 50 * time.Millisecond
+	breaker.OnStateChange = func(key string, from int, to int) {
+		changes = append(changes, strings.Repeat("x", from)+">"+strings.Repeat("x", to))
+	}
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_CIRCUIT_BREAKER: breaker,
+	})
+
+	key := strings.TrimPrefix(server.URL, "http://")
+	for i := 0; i < 3; i++ {
+		res, err := c.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if breaker.State(key) != CIRCUIT_OPEN {
+		t.Fatal("circuit should be open")
+	}
+
+	_, err := c.Get(server.URL)
+	if !IsCircuitOpenError(err) || atomic.LoadInt32(&hits) != 3 {
+		t.Error("requests should fail fast:", err)
+	}
+
+	// failed probe
+	time.Sleep(60 * time.Millisecond)
+	res, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if breaker.State(key) != CIRCUIT_OPEN {
+		t.Error("circuit should be open again")
+	}
+
+	// recovered
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(60 * time.Millisecond)
+	res, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if breaker.State(key) != CIRCUIT_CLOSED {
+		t.Error("circuit should be closed")
+	}
+
+	expected := ">x,x>xx,xx>x,x>xx,xx>"
+	if strings.Join(changes, ",") != expected {
+		t.Error("wrong state changes:", changes)
+	}
+
+	// other hosts are not affected
+	if breaker.State("localhost") != CIRCUIT_CLOSED {
+		t.Error("wrong state")
+	}
+}
+
+func TestCircuitBreakerAbandoned(t *testing.T) {
+	var failing int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker()
+	breaker.FailureThreshold = 1
+	breaker.OpenTimeout = 50 * time.Millisecond
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_CIRCUIT_BREAKER: breaker,
+	})
+
+	key := strings.TrimPrefix(server.URL, "http://")
+	res, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if breaker.State(key) != CIRCUIT_OPEN {
+		t.Fatal("circuit should be open")
+	}
+
+	// the canceled probe neither counts nor takes the slot
+	time.Sleep(60 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := c.WithOption(OPT_CONTEXT, ctx).Get(server.URL + "/slow"); err == nil {
+		t.Fatal("request should be canceled")
+	}
+
+	if breaker.State(key) != CIRCUIT_HALF_OPEN {
+		t.Error("circuit should be half open")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	res, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal("probe should be allowed:", err)
+	}
+	res.Body.Close()
+
+	if breaker.State(key) != CIRCUIT_CLOSED {
+		t.Error("circuit should be closed")
+	}
+
+	// timed out while waiting for the rate limiter, the request is not sent
+	breaker = NewCircuitBreaker()
+	breaker.FailureThreshold = 1
+	c = NewHttpClient().Defaults(Map{
+		OPT_CIRCUIT_BREAKER: breaker,
+		OPT_RATE_LIMIT:      NewRateLimiter(0.1, 1),
+	})
+
+	res, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.WithOption(OPT_CONTEXT, ctx).Get(server.URL)
+	if !IsTimeoutError(err) {
+		t.Error("waiting should time out:", err)
+	}
+
+	if breaker.State(key) != CIRCUIT_CLOSED {
+		t.Error("circuit should be closed")
+	}
+}
+
+func TestCircuitBreakerRatio(t *testing.T) {
+	breaker := &CircuitBreaker{
+		FailureThreshold: -1,
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		HalfOpenRequests: 2,
+		FailureStatus:    []int{429},
+	}
+
+	now := time.Now()
+	results := []bool{true, false, true}
+	for _, failed := range results {
+		if err := breaker.allow("a", now); err != nil {
+			t.Fatal(err)
+		}
+		breaker.record("a", failed, now)
+	}
+
+	if breaker.State("a") != CIRCUIT_CLOSED {
+		t.Error("circuit should be closed before min requests")
+	}
+
+	breaker.allow("a", now)
+	breaker.record("a", false, now)
+	if breaker.State("a") != CIRCUIT_OPEN {
+		t.Error("circuit should be open")
+	}
+
+	// half open with two probes
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := breaker.allow("a", now); err != nil {
+			t.Fatal("probe should be allowed:", err)
+		}
+	}
+
+	if err := breaker.allow("a", now); getErrorCode(err) != ERR_CIRCUIT_OPEN {
+		t.Error("only probes are allowed while half open")
+	}
+
+	breaker.record("a", false, now)
+	if breaker.State("a") != CIRCUIT_HALF_OPEN {
+		t.Error("circuit should be half open")
+	}
+
+	breaker.record("a", false, now)
+	if breaker.State("a") != CIRCUIT_CLOSED {
+		t.Error("circuit should be closed")
+	}
+
+	if !breaker.isFailure(&http.Response{StatusCode: 429}, nil) || breaker.isFailure(&http.Response{StatusCode: 500}, nil) {
+		t.Error("wrong failure status")
+	}
+}
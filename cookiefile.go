@@ -0,0 +1,268 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of cookie files.
+const (
+	// The cookies.txt format of Netscape and curl.
+	COOKIE_FORMAT_NETSCAPE = iota
+
+	// JSON array of JarCookie.
+	COOKIE_FORMAT_JSON
+)
+
+// Prefix of HttpOnly cookies in the Netscape format.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Format of the cookie file by its extension, files ending with ".json" are
+// in JSON, others in the Netscape format.
+func cookieFileFormat(path string) int {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return COOKIE_FORMAT_JSON
+	}
+
+	return COOKIE_FORMAT_NETSCAPE
+}
+
+// Copies of all unexpired cookies, sorted by domain, path and name.
+func (this *Jar) all(now time.Time) []*JarCookie {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var cookies []*JarCookie
+	for key, submap := range this.entries {
+		for id, c := range submap {
+			if c.expired(now) {
+				delete(submap, id)
+				continue
+			}
+
+			cp := *c
+			cookies = append(cookies, &cp)
+		}
+
+		if len(submap) == 0 {
+			delete(this.entries, key)
+		}
+	}
+
+	sort.Slice(cookies, func(i, j int) bool {
+		if cookies[i].Domain != cookies[j].Domain {
+			return cookies[i].Domain < cookies[j].Domain
+		}
+		if cookies[i].Path != cookies[j].Path {
+			return cookies[i].Path < cookies[j].Path
+		}
+		return cookies[i].Name < cookies[j].Name
+	})
+
+	return cookies
+}
+
+// Add cookies as they are, expired cookies are ignored.
+func (this *Jar) addAll(cookies []*JarCookie, now time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, c := range cookies {
+		if c.expired(now) || c.Name == "" || c.Domain == "" {
+			continue
+		}
+
+		cp := *c
+		cp.Domain = strings.ToLower(strings.TrimPrefix(cp.Domain, "."))
+		if cp.Path == "" {
+			cp.Path = "/"
+		}
+		this.add(&cp, now)
+	}
+}
+
+// Load cookies from a reader, existing cookies with the same domain, path and
+// name are replaced.
+func (this *Jar) Load(r io.Reader, format int) error {
+	var cookies []*JarCookie
+	var err error
+	switch format {
+	case COOKIE_FORMAT_NETSCAPE:
+		cookies, err = readNetscapeCookies(r)
+	case COOKIE_FORMAT_JSON:
+		err = json.NewDecoder(r).Decode(&cookies)
+	default:
+		err = fmt.Errorf("unknown cookie format: %d", format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	this.addAll(cookies, time.Now())
+
+	return nil
+}
+
+// Save cookies(including session cookies) to a writer.
+func (this *Jar) Save(w io.Writer, format int) error {
+	cookies := this.all(time.Now())
+	switch format {
+	case COOKIE_FORMAT_NETSCAPE:
+		return writeNetscapeCookies(w, cookies)
+	case COOKIE_FORMAT_JSON:
+		if cookies == nil {
+			cookies = []*JarCookie{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(cookies)
+	default:
+		return fmt.Errorf("unknown cookie format: %d", format)
+	}
+}
+
+// Load cookies from a file, the format is decided by the extension.
+func (this *Jar) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return this.Load(f, cookieFileFormat(path))
+}
+
+// Save cookies to a file, the format is decided by the extension. The file is
+// replaced atomically, "-" means stdout(like curl).
+func (this *Jar) SaveFile(path string) error {
+	if path == "-" {
+		return this.Save(os.Stdout, cookieFileFormat(path))
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := this.Save(f, cookieFileFormat(path)); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Read cookies in the Netscape format:
+//
+//	domain  include_subdomains  path  secure  expires  name  value
+func readNetscapeCookies(r io.Reader) ([]*JarCookie, error) {
+	var cookies []*JarCookie
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			httpOnly = true
+			line = line[len(httpOnlyPrefix):]
+		} else if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		// the value might be empty
+		if len(fields) == 6 {
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("invalid cookie file at line %d", lineno)
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie expires at line %d: %v", lineno, err)
+		}
+
+		c := &JarCookie{
+			Domain:   strings.TrimPrefix(fields[0], "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+
+		cookies = append(cookies, c)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cookies, nil
+}
+
+// Write cookies in the Netscape format.
+func writeNetscapeCookies(w io.Writer, cookies []*JarCookie) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n")
+	bw.WriteString("# This file was generated by " + USERAGENT + "! Edit at your own risk.\n\n")
+
+	boolString := func(b bool) string {
+		if b {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+
+	for _, c := range cookies {
+		domain := c.Domain
+		if !c.HostOnly {
+			domain = "." + domain
+		}
+		if c.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, boolString(!c.HostOnly),
+			c.Path, boolString(c.Secure), expires, c.Name, c.Value)
+	}
+
+	return bw.Flush()
+}
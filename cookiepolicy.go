@@ -0,0 +1,251 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Policy of the default cookie jar, set it with OPT_COOKIE_POLICY or
+// Jar.SetPolicy. Fields should not be changed once the policy is in use.
+//
+// The top level request is the request sent by the client, requests of
+// redirects are not top level.
+type CookiePolicy struct {
+	// Reject and do not send cookies of sites(registrable domains) other than
+	// the site of the top level request.
+	BlockThirdParty bool
+
+	// Secure cookies can only be set over https, and can not be overwritten
+	// over http. Cookie name prefixes "__Secure-" and "__Host-" are enforced.
+	StrictSecure bool
+
+	// SameSite=Strict cookies are not sent to a site other than the site of
+	// the top level request, SameSite=Lax cookies are only sent to other sites
+	// with safe methods(GET, HEAD). SameSite=None cookies must be Secure.
+	EnforceSameSite bool
+
+	// HttpOnly cookies are hidden from non-HTTP APIs(HttpClient.Cookies and
+	// Jar.All), they are still sent with requests and saved to cookie files.
+	HideHttpOnly bool
+
+	// Maximum number of cookies of a site(registrable domain), zero means no
+	// limit. The least recently used cookies are evicted.
+	MaxPerDomain int
+
+	// Maximum number of cookies of the jar, zero means no limit. The least
+	// recently used cookies are evicted.
+	MaxTotal int
+
+	// Called for every received cookie which passes other rules, return false
+	// to reject it.
+	Accept func(u *url.URL, cookie *http.Cookie) bool
+}
+
+// Set the policy of the jar, nil means no policy.
+func (this *Jar) SetPolicy(policy *CookiePolicy) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.policy = policy
+}
+
+// The context of a top level request.
+type cookieSite struct {
+	// Site(registrable domain) of the top level request.
+	site string
+
+	// Method of the top level request.
+	method string
+}
+
+// The cookie jar used by a single request, so that the jar knows the top
+// level request.
+type requestJar struct {
+	jar  *Jar
+	site *cookieSite
+}
+
+// Wrap the jar with the top level request.
+func (this *Jar) forRequest(req *http.Request) http.CookieJar {
+	host, err := canonicalHost(req.URL.Host)
+	if err != nil {
+		return this
+	}
+
+	return &requestJar{
+		jar: this,
+		site: &cookieSite{
+			site:   this.jarKey(host),
+			method: req.Method,
+		},
+	}
+}
+
+// Implement http.CookieJar.
+func (this *requestJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	this.jar.setCookies(u, this.site, cookies, time.Now())
+}
+
+// Implement http.CookieJar.
+func (this *requestJar) Cookies(u *url.URL) []*http.Cookie {
+	return toHttpCookies(this.jar.cookies(u, this.site, time.Now()))
+}
+
+// Whether the host is of a site other than the top level request. Must be
+// called with the lock.
+func (this *Jar) crossSite(host string, site *cookieSite) bool {
+	return site != nil && this.jarKey(host) != site.site
+}
+
+// Check a received cookie with the policy. Must be called with the lock.
+func (this *Jar) acceptCookie(u *url.URL, host string, site *cookieSite, cookie *http.Cookie, c *JarCookie) bool {
+	policy := this.policy
+	if policy == nil {
+		return true
+	}
+
+	if policy.BlockThirdParty && this.crossSite(host, site) {
+		return false
+	}
+
+	if policy.StrictSecure {
+		https := u.Scheme == "https"
+		if c.Secure && !https {
+			return false
+		}
+
+		if strings.HasPrefix(c.Name, "__Secure-") && !(c.Secure && https) {
+			return false
+		}
+
+		if strings.HasPrefix(c.Name, "__Host-") && !(c.Secure && https && c.HostOnly && c.Path == "/") {
+			return false
+		}
+
+		// leave secure cookies alone
+		if !https && this.shadowsSecure(c) {
+			return false
+		}
+	}
+
+	if policy.EnforceSameSite && c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return false
+	}
+
+	if policy.Accept != nil && !policy.Accept(u, cookie) {
+		return false
+	}
+
+	return true
+}
+
+// Whether the cookie would overwrite a secure cookie. Must be called with the
+// lock.
+func (this *Jar) shadowsSecure(c *JarCookie) bool {
+	for _, old := range this.entries[this.jarKey(c.Domain)] {
+		if !old.Secure || old.Name != c.Name {
+			continue
+		}
+
+		if (domainMatch(old.Domain, c.Domain) || domainMatch(c.Domain, old.Domain)) && pathMatch(c.Path, old.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check a cookie to send with the policy. Must be called with the lock.
+func (this *Jar) sendCookie(host string, site *cookieSite, c *JarCookie) bool {
+	policy := this.policy
+	if policy == nil || !this.crossSite(host, site) {
+		return true
+	}
+
+	if policy.BlockThirdParty {
+		return false
+	}
+
+	if policy.EnforceSameSite {
+		switch c.SameSite {
+		case http.SameSiteStrictMode:
+			return false
+		case http.SameSiteLaxMode:
+			return site.method == "GET" || site.method == "HEAD"
+		}
+	}
+
+	return true
+}
+
+// Evict the least recently used cookies to respect the limits of the policy.
+// Must be called with the lock.
+func (this *Jar) evict(key string, now time.Time) {
+	policy := this.policy
+	if policy == nil || (policy.MaxPerDomain <= 0 && policy.MaxTotal <= 0) {
+		return
+	}
+
+	if policy.MaxPerDomain > 0 && len(this.entries[key]) > policy.MaxPerDomain {
+		this.evictFrom(map[string]map[string]*JarCookie{key: this.entries[key]},
+			len(this.entries[key])-policy.MaxPerDomain, now)
+	}
+
+	if policy.MaxTotal > 0 {
+		total := 0
+		for _, submap := range this.entries {
+			total += len(submap)
+		}
+		if total > policy.MaxTotal {
+			this.evictFrom(this.entries, total-policy.MaxTotal, now)
+		}
+	}
+}
+
+// Remove n cookies from entries, expired cookies first, then the least
+// recently used ones. Must be called with the lock.
+func (this *Jar) evictFrom(entries map[string]map[string]*JarCookie, n int, now time.Time) {
+	var candidates []*JarCookie
+	for _, submap := range entries {
+		for _, c := range submap {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ei, ej := candidates[i].expired(now), candidates[j].expired(now)
+		if ei != ej {
+			return ei
+		}
+		if !candidates[i].LastAccess.Equal(candidates[j].LastAccess) {
+			return candidates[i].LastAccess.Before(candidates[j].LastAccess)
+		}
+		return candidates[i].seqNum < candidates[j].seqNum
+	})
+
+	for i := 0; i < n && i < len(candidates); i++ {
+		this.remove(candidates[i])
+	}
+}
+
+// Prepare the cookie policy with OPT_COOKIE_POLICY.
+func prepareCookiePolicy(options map[int]interface{}) (*CookiePolicy, error) {
+	policy_, ok := options[OPT_COOKIE_POLICY]
+	if !ok || policy_ == nil {
+		return nil, nil
+	}
+
+	policy, ok := policy_.(*CookiePolicy)
+	if !ok {
+		return nil, fmt.Errorf("OPT_COOKIE_POLICY must be *httpclient.CookiePolicy")
+	}
+
+	return policy, nil
+}
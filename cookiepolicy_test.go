@@ -0,0 +1,192 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCookiePolicyThirdParty(t *testing.T) {
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "tracker", Value: "1"})
+		c, _ := r.Cookie("tracker")
+		if c != nil {
+			io.WriteString(w, "tracked")
+		}
+	}))
+	defer tracker.Close()
+
+	// the same server with another site name
+	trackerURL := strings.Replace(tracker.URL, "127.0.0.1", "localhost", 1)
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "site", Value: "1"})
+		http.Redirect(w, r, trackerURL, 302)
+	}))
+	defer site.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_COOKIE_POLICY: &CookiePolicy{
+			BlockThirdParty: true,
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(site.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body, _ := res.ToString(); body != "" {
+			t.Error("third-party cookies should be blocked")
+		}
+	}
+
+	if c.CookieValue(site.URL, "site") != "1" {
+		t.Error("first-party cookies should be accepted")
+	}
+
+	if c.CookieValue(trackerURL, "tracker") != "" {
+		t.Error("third-party cookies should be rejected")
+	}
+
+	// first-party
+	res, err := c.Get(trackerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ToString()
+
+	res, err = c.Get(site.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); body != "" {
+		t.Error("cookies should not be sent in third-party context")
+	}
+
+	if c.CookieValue(trackerURL, "tracker") != "1" {
+		t.Error("first-party cookies should be accepted")
+	}
+}
+
+func TestCookiePolicySecure(t *testing.T) {
+	jar := NewJar()
+	jar.SetPolicy(&CookiePolicy{
+		StrictSecure:    true,
+		EnforceSameSite: true,
+	})
+
+	https, _ := url.Parse("https://www.example.com/")
+	jar.SetCookies(https, []*http.Cookie{
+		{Name: "secure", Value: "1", Secure: true},
+		{Name: "__Secure-a", Value: "1", Secure: true},
+		{Name: "__Host-a", Value: "1", Secure: true, Path: "/"},
+		{Name: "__Host-b", Value: "1", Secure: true, Path: "/", Domain: "example.com"},
+		{Name: "none", Value: "1", SameSite: http.SameSiteNoneMode},
+	})
+
+	http_, _ := url.Parse("http://www.example.com/")
+	jar.SetCookies(http_, []*http.Cookie{
+		{Name: "insecure", Value: "1", Secure: true},
+		{Name: "__Secure-b", Value: "1"},
+		{Name: "secure", Value: "overwritten"},
+	})
+
+	if names := jarCookieNames(jar, "https://www.example.com/"); names != "secure,__Secure-a,__Host-a" {
+		t.Error("wrong cookies:", names)
+	}
+
+	if names := jarCookieNames(jar, "http://www.example.com/"); names != "" {
+		t.Error("wrong cookies:", names)
+	}
+}
+
+func TestCookiePolicySameSite(t *testing.T) {
+	jar := NewJar()
+	jar.SetPolicy(&CookiePolicy{
+		EnforceSameSite: true,
+	})
+
+	u, _ := url.Parse("https://example.com/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "strict", Value: "1", SameSite: http.SameSiteStrictMode},
+		{Name: "lax", Value: "1", SameSite: http.SameSiteLaxMode},
+		{Name: "none", Value: "1", SameSite: http.SameSiteNoneMode, Secure: true},
+		{Name: "default", Value: "1"},
+	})
+
+	cases := []struct {
+		top      string
+		method   string
+		expected string
+	}{
+		{"https://www.example.com/", "POST", "strict,lax,none,default"},
+		{"https://other.com/", "GET", "lax,none,default"},
+		{"https://other.com/", "POST", "none,default"},
+	}
+
+	for _, v := range cases {
+		req, _ := http.NewRequest(v.method, v.top, nil)
+		if names := jarCookieNames(jar.forRequest(req), "https://example.com/"); names != v.expected {
+			t.Error("wrong cookies:", v.top, v.method, names)
+		}
+	}
+}
+
+func TestCookiePolicyLimits(t *testing.T) {
+	accepted := 0
+	jar := NewJar()
+	jar.SetPolicy(&CookiePolicy{
+		MaxPerDomain: 3,
+		MaxTotal:     5,
+		HideHttpOnly: true,
+		Accept: func(u *url.URL, cookie *http.Cookie) bool {
+			accepted++
+			return cookie.Name != "rejected"
+		},
+	})
+
+	u, _ := url.Parse("http://example.com/")
+	for i := 0; i < 5; i++ {
+		jar.SetCookies(u, []*http.Cookie{{Name: "a" + strconv.Itoa(i), Value: "1"}})
+	}
+	jar.SetCookies(u, []*http.Cookie{{Name: "rejected", Value: "1"}})
+
+	// recently used
+	jarCookieNames(jar, "http://example.com/")
+
+	if accepted != 6 {
+		t.Error("accept callback is not called:", accepted)
+	}
+
+	if names := jarCookieNames(jar, "http://example.com/"); names != "a2,a3,a4" {
+		t.Error("wrong cookies:", names)
+	}
+
+	u, _ = url.Parse("http://other.com/")
+	for i := 0; i < 3; i++ {
+		jar.SetCookies(u, []*http.Cookie{{Name: "b" + strconv.Itoa(i), Value: "1", HttpOnly: i == 0}})
+	}
+
+	if n := len(jar.Snapshot()); n != 5 {
+		t.Error("wrong number of cookies:", n)
+	}
+
+	if names := jarCookieNames(jar, "http://other.com/"); names != "b0,b1,b2" {
+		t.Error("newest cookies should be kept:", names)
+	}
+
+	all := jar.All()
+	if len(all) != 4 {
+		t.Error("HttpOnly cookies should be hidden:", all)
+	}
+}
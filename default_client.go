@@ -34,6 +34,13 @@ var WithOptions = defaultClient.WithOptions
 var WithHeader = defaultClient.WithHeader
 var WithHeaders = defaultClient.WithHeaders
 var WithCookie = defaultClient.WithCookie
+var WithRequestCookie = defaultClient.WithRequestCookie
 var Cookies = defaultClient.Cookies
 var CookieValues = defaultClient.CookieValues
 var CookieValue = defaultClient.CookieValue
+var SaveCookies = defaultClient.SaveCookies
+var CookieJar = defaultClient.CookieJar
+var Batch = defaultClient.Batch
+var Parallel = defaultClient.Parallel
+var Download = defaultClient.Download
+var Close = defaultClient.Close
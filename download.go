@@ -0,0 +1,461 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Options of Download.
+type DownloadOptions struct {
+	// Do not resume from the partial file of an interrupted download.
+	NoResume bool
+
+	// Download the number of ranges concurrently if the server supports
+	// ranges. Segmented downloads are not resumed, they start over.
+	Segments int
+
+	// Retries of a failed segment, default to 3.
+	SegmentRetries int
+
+	// Checksum of the file, in the form of "algorithm:hex"(e.g.
+	// "sha256:9f86d0..."), md5, sha1, sha256 and sha512 are supported.
+	Checksum string
+}
+
+// Validators of a partial download, stored next to the partial file.
+type downloadMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"lastModified"`
+
+	// The partial file is preallocated by a segmented download, it can not
+	// be resumed.
+	Segmented bool `json:"segmented,omitempty"`
+}
+
+// The If-Range validator, weak ETags can not be used.
+func (this *downloadMeta) validator() string {
+	if this.ETag != "" && !strings.HasPrefix(this.ETag, "W/") {
+		return this.ETag
+	}
+
+	return this.LastModified
+}
+
+func readDownloadMeta(path string) *downloadMeta {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	meta := &downloadMeta{}
+	if json.Unmarshal(data, meta) != nil {
+		return nil
+	}
+
+	return meta
+}
+
+func writeDownloadMeta(path string, meta *downloadMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Parse Content-Range of a 206 or 416 response, -1 means unknown.
+func parseContentRange(s string) (start, end, total int64, err error) {
+	start, end, total = -1, -1, -1
+	if !strings.HasPrefix(s, "bytes ") {
+		return start, end, total, fmt.Errorf("invalid Content-Range: %q", s)
+	}
+
+	s = strings.TrimSpace(s[len("bytes "):])
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return start, end, total, fmt.Errorf("invalid Content-Range: %q", s)
+	}
+
+	if s[i+1:] != "*" {
+		if total, err = strconv.ParseInt(s[i+1:], 10, 64); err != nil {
+			return
+		}
+	}
+
+	if s[:i] != "*" {
+		parts := strings.SplitN(s[:i], "-", 2)
+		if len(parts) != 2 {
+			return start, end, total, fmt.Errorf("invalid Content-Range: %q", s)
+		}
+		if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return
+		}
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return
+		}
+	}
+
+	return start, end, total, nil
+}
+
+// Cancel the download when no data is received for a while.
+type stallTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+func newStallTimer(timeout time.Duration, cancel func()) *stallTimer {
+	this := &stallTimer{
+		timeout: timeout,
+	}
+
+	if timeout > 0 {
+		this.timer = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&this.fired, 1)
+			cancel()
+		})
+	}
+
+	return this
+}
+
+func (this *stallTimer) reset() {
+	if this.timer != nil {
+		this.timer.Reset(this.timeout)
+	}
+}
+
+func (this *stallTimer) stop() {
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+}
+
+// Replace the error of a stalled download with a timeout error.
+func (this *stallTimer) check(err error) error {
+	if err != nil && atomic.LoadInt32(&this.fired) == 1 {
+		return &Error{
+			Code:    ERR_TIMEOUT,
+			Message: fmt.Sprintf("download timeout: no data received in %v", this.timeout),
+		}
+	}
+
+	return err
+}
+
+// A reader resets the stall timer when data is received.
+type stallReader struct {
+	reader io.Reader
+	timer  *stallTimer
+}
+
+func (this *stallReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	if n > 0 {
+		this.timer.reset()
+	}
+
+	return n, err
+}
+
+// Download the url to a file. The body is written to a partial file
+// (path + ".part"), which is renamed to path when the download completes, an
+// interrupted download is resumed from the partial file.
+//
+// OPT_TIMEOUT(and OPT_TIMEOUT_MS) limits the time without receiving any data
+// instead of the whole transfer. The response is returned with the body
+// closed, it's the response of the first range request for segmented
+// downloads.
+func (this *HttpClient) Download(url string, path string, options *DownloadOptions) (*Response, error) {
+	if options == nil {
+		options = &DownloadOptions{}
+	}
+
+	// keep one time options, the request might be sent again
+	oneTimeOptions := this.oneTimeOptions
+	oneTimeHeaders := this.oneTimeHeaders
+	oneTimeCookies := this.oneTimeCookies
+	oneTimeRequestCookies := this.oneTimeRequestCookies
+
+	// one time options are consumed by the first request, restore them for
+	// the others
+	restore := func() {
+		this.Begin()
+		for k, v := range oneTimeOptions {
+			this.WithOption(k, v)
+		}
+		this.WithHeaders(oneTimeHeaders)
+		this.WithCookie(oneTimeCookies...)
+		this.WithRequestCookie(oneTimeRequestCookies...)
+	}
+
+	merged := mergeOptions(defaultOptions, this.options, oneTimeOptions)
+	timeout, err := prepareTimeout(merged)
+	if err != nil {
+		this.reset()
+		return nil, err
+	}
+
+	checksum, err := parseChecksum(options.Checksum)
+	if err != nil {
+		this.reset()
+		return nil, err
+	}
+
+	parent := context.Background()
+	if ctx, ok := merged[OPT_CONTEXT].(context.Context); ok {
+		parent = ctx
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	started := false
+	if options.Segments > 1 {
+		res, ok, err := this.downloadSegments(ctx, url, path, options, timeout, checksum, restore)
+		if ok || err != nil {
+			return res, err
+		}
+
+		// ranges are not supported
+		started = true
+	}
+
+	timer := newStallTimer(timeout, cancel)
+	defer timer.stop()
+
+	resume := !options.NoResume
+	for attempt := 0; ; attempt++ {
+		if started {
+			restore()
+		}
+		started = true
+
+		this.WithOption(OPT_CONTEXT, ctx)
+		this.withoutTimeout()
+
+		res, restart, err := this.download(url, path, resume, timer, checksum)
+		if restart && attempt == 0 {
+			resume = false
+			timer.reset()
+			continue
+		}
+
+		return res, timer.check(err)
+	}
+}
+
+// Download once, restart is true if the partial file can not be resumed.
+func (this *HttpClient) download(url string, path string, resume bool, timer *stallTimer, checksum *downloadChecksum) (*Response, bool, error) {
+	partPath := path + ".part"
+	metaPath := partPath + ".json"
+
+	var offset int64
+	meta := readDownloadMeta(metaPath)
+	if resume && meta != nil && meta.URL == url && !meta.Segmented && meta.validator() != "" {
+		if info, err := os.Stat(partPath); err == nil {
+			offset = info.Size()
+		}
+	}
+
+	headers := map[string]string{
+		// ranges of compressed content are not reliable
+		"Accept-Encoding": "identity",
+	}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+		headers["If-Range"] = meta.validator()
+	}
+
+	res, err := this.Do("GET", url, headers, nil)
+	if err != nil {
+		return res, false, err
+	}
+	defer res.Body.Close()
+
+	expected := int64(-1)
+	flag := os.O_RDWR | os.O_CREATE
+	switch res.StatusCode {
+	case 200:
+		offset = 0
+		flag |= os.O_TRUNC
+		expected = res.ContentLength
+	case 206:
+		start, _, total, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err != nil {
+			return res, false, err
+		}
+		if start != offset {
+			return res, offset > 0, fmt.Errorf("download: unexpected range start %d, expected %d", start, offset)
+		}
+		flag |= os.O_APPEND
+		expected = total
+	case 416:
+		_, _, total, _ := parseContentRange(res.Header.Get("Content-Range"))
+		if offset > 0 && total == offset {
+			// already completed
+			return res, false, finishDownload(partPath, metaPath, path, nil, checksum)
+		}
+		return res, offset > 0, fmt.Errorf("download: unexpected status %s", res.Status)
+	default:
+		return res, false, fmt.Errorf("download: unexpected status %s", res.Status)
+	}
+
+	newMeta := &downloadMeta{
+		URL:          url,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+	if err := writeDownloadMeta(metaPath, newMeta); err != nil {
+		return res, false, err
+	}
+
+	f, err := os.OpenFile(partPath, flag, 0644)
+	if err != nil {
+		return res, false, err
+	}
+
+	// the partial file is kept on errors to resume later
+	_, err = io.Copy(f, &stallReader{res.Body, timer})
+	if err != nil {
+		f.Close()
+		return res, false, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return res, false, err
+	}
+
+	if expected >= 0 && info.Size() != expected {
+		f.Close()
+		if info.Size() > expected {
+			os.Remove(partPath)
+			os.Remove(metaPath)
+		}
+		return res, false, fmt.Errorf("download: got %d bytes, expected %d", info.Size(), expected)
+	}
+
+	return res, false, finishDownload(partPath, metaPath, path, f, checksum)
+}
+
+// Verify and sync the partial file, and rename it to the path. The partial
+// file is removed if the checksum does not match.
+func finishDownload(partPath string, metaPath string, path string, f *os.File, checksum *downloadChecksum) error {
+	if f == nil {
+		var err error
+		if f, err = os.OpenFile(partPath, os.O_RDWR, 0); err != nil {
+			return err
+		}
+	}
+
+	if checksum != nil {
+		if err := checksum.verify(f); err != nil {
+			f.Close()
+			os.Remove(partPath)
+			os.Remove(metaPath)
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(partPath, path); err != nil {
+		return err
+	}
+
+	os.Remove(metaPath)
+
+	return nil
+}
+
+// Expected checksum of a download.
+type downloadChecksum struct {
+	algorithm string
+	hash      func() hash.Hash
+	sum       []byte
+}
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Parse checksum in the form of "algorithm:hex", nil if it's empty.
+func parseChecksum(s string) (*downloadChecksum, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid checksum: %q", s)
+	}
+
+	algorithm := strings.ToLower(parts[0])
+	h, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm: %q", parts[0])
+	}
+
+	sum, err := hex.DecodeString(parts[1])
+	if err != nil || len(sum) != h().Size() {
+		return nil, fmt.Errorf("invalid checksum: %q", s)
+	}
+
+	return &downloadChecksum{
+		algorithm: algorithm,
+		hash:      h,
+		sum:       sum,
+	}, nil
+}
+
+// Verify the checksum of the file.
+func (this *downloadChecksum) verify(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h := this.hash()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, info.Size())); err != nil {
+		return err
+	}
+
+	if sum := h.Sum(nil); !bytes.Equal(sum, this.sum) {
+		return fmt.Errorf("download: %s checksum mismatch, got %x, expected %x", this.algorithm, sum, this.sum)
+	}
+
+	return nil
+}
@@ -0,0 +1,371 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	cases := map[string][3]int64{
+		"bytes 0-99/200":  {0, 99, 200},
+		"bytes 100-199/*": {100, 199, -1},
+		"bytes */200":     {-1, -1, 200},
+	}
+
+	for s, expected := range cases {
+		start, end, total, err := parseContentRange(s)
+		if err != nil || start != expected[0] || end != expected[1] || total != expected[2] {
+			t.Error("wrong content range:", s, start, end, total, err)
+		}
+	}
+
+	if _, _, _, err := parseContentRange("items 0-1/2"); err == nil {
+		t.Error("invalid content range should fail")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	etag := `"v1"`
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == "/stall" {
+			w.Header().Set("Content-Length", "10000")
+			w.Write(content[:100])
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	check := func(expectedRange string) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Error("wrong file:", len(data), err)
+		}
+
+		if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+			t.Error("partial file should be removed")
+		}
+
+		if _, err := os.Stat(path + ".part.json"); !os.IsNotExist(err) {
+			t.Error("meta file should be removed")
+		}
+
+		if r := ranges[len(ranges)-1]; r != expectedRange {
+			t.Error("wrong range:", r)
+		}
+	}
+
+	c := NewHttpClient()
+	res, err := c.Download(server.URL, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Error("wrong status:", res.StatusCode)
+	}
+	check("")
+
+	// resume
+	partial := func(n int, etag string) {
+		ioutil.WriteFile(path+".part", content[:n], 0644)
+		writeDownloadMeta(path+".part.json", &downloadMeta{URL: server.URL, ETag: etag})
+	}
+
+	partial(4000, etag)
+	res, err = c.Download(server.URL, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 206 {
+		t.Error("wrong status:", res.StatusCode)
+	}
+	check("bytes=4000-")
+
+	// changed
+	partial(4000, `"v0"`)
+	if _, err = c.Download(server.URL, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("bytes=4000-")
+
+	// completed but not renamed
+	partial(len(content), etag)
+	if _, err = c.Download(server.URL, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("bytes=10000-")
+
+	// no resume
+	partial(4000, etag)
+	if _, err = c.Download(server.URL, path, &DownloadOptions{NoResume: true}); err != nil {
+		t.Fatal(err)
+	}
+	check("")
+
+	// stalled, OPT_TIMEOUT is not a limit of the whole transfer
+	stalled := filepath.Join(dir, "stalled")
+	_, err = c.WithOption(OPT_TIMEOUT_MS, 100).Download(server.URL+"/stall", stalled, nil)
+	if !IsTimeoutError(err) || !strings.Contains(err.Error(), "download timeout") {
+		t.Error("stalled download should time out:", err)
+	}
+
+	if data, _ := ioutil.ReadFile(stalled + ".part"); len(data) != 100 {
+		t.Error("partial file should be kept:", len(data))
+	}
+
+	// 404
+	res, err = c.Download(server.URL+"/missing", filepath.Join(dir, "missing"), nil)
+	if err == nil || res.StatusCode != 404 {
+		t.Error("unexpected status should fail:", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "missing.part")); !os.IsNotExist(err) {
+		t.Error("nothing should be written")
+	}
+}
+
+func TestDownloadConnections(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 30000)
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the transport of the client is reused by downloads
+	c := NewHttpClient().Defaults(Map{
+		OPT_TIMEOUT: 10,
+	})
+	for i := 0; i < 10; i++ {
+		if _, err := c.Download(server.URL, filepath.Join(dir, "file"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Error("connections should be reused:", n)
+	}
+
+	// idle connections are kept up to 2 per host(the default of
+	// http.Transport), so at most 2 of the 4 segments connect again
+	atomic.StoreInt32(&conns, 0)
+	for i := 0; i < 5; i++ {
+		_, err := c.Download(server.URL, filepath.Join(dir, "file"), &DownloadOptions{
+			Segments: 4,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&conns); n > 4+4*2 {
+		t.Error("connections should be reused:", n)
+	}
+}
+
+func TestDownloadSegments(t *testing.T) {
+	content := make([]byte, 1<<20)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	var lock sync.Mutex
+	var ranges []string
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		fail := !failed && r.Header.Get("Range") == "bytes=262144-524287"
+		if fail {
+			failed = true
+		}
+		lock.Unlock()
+
+		if r.URL.Path == "/norange" {
+			w.Write(content)
+			return
+		}
+
+		// the connection is broken in the middle of a segment
+		if fail {
+			w.Header().Set("Content-Range", "bytes 262144-524287/1048576")
+			w.Header().Set("Content-Length", "262144")
+			w.WriteHeader(206)
+			w.Write(content[262144:300000])
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	_, err = NewHttpClient().Download(server.URL, path, &DownloadOptions{
+		Segments: 4,
+		Checksum: checksum,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(path); !bytes.Equal(data, content) {
+		t.Error("wrong content")
+	}
+
+	if _, err := os.Stat(path + ".part.json"); !os.IsNotExist(err) {
+		t.Error("meta file should be removed")
+	}
+
+	// probe, 4 segments and a retry from the broken position
+	sort.Strings(ranges)
+	expected := []string{
+		"bytes=0-0",
+		"bytes=0-262143",
+		"bytes=262144-524287",
+		"bytes=300000-524287",
+		"bytes=524288-786431",
+		"bytes=786432-1048575",
+	}
+	if strings.Join(ranges, ",") != strings.Join(expected, ",") {
+		t.Error("wrong ranges:", ranges)
+	}
+
+	// ranges are not supported
+	ranges = nil
+	path = filepath.Join(dir, "norange")
+	_, err = NewHttpClient().Download(server.URL+"/norange", path, &DownloadOptions{
+		Segments: 4,
+		Checksum: checksum,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(path); !bytes.Equal(data, content) {
+		t.Error("wrong content")
+	}
+
+	if len(ranges) != 2 {
+		t.Error("wrong ranges:", ranges)
+	}
+
+	// checksum mismatch
+	path = filepath.Join(dir, "mismatch")
+	_, err = NewHttpClient().Download(server.URL, path, &DownloadOptions{
+		Segments: 4,
+		Checksum: "md5:" + strings.Repeat("0", 32),
+	})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Error("checksum should mismatch:", err)
+	}
+
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Error("partial file should be removed")
+	}
+
+	if _, err := NewHttpClient().Download(server.URL, path, &DownloadOptions{
+		Checksum: "crc32:00000000",
+	}); err == nil {
+		t.Error("unsupported checksum should fail")
+	}
+}
+
+// The probe of a server which does not support ranges is not read to the end.
+func TestDownloadSegmentsNoRange(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	probed := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "bytes=0-0" {
+			w.Write(content)
+			return
+		}
+
+		// the range is ignored, wait for the client before sending the rest
+		w.Write(content[:1000])
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			probed <- true
+		case <-time.After(2 * time.Second):
+			w.Write(content[1000:])
+			probed <- false
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	_, err = NewHttpClient().Download(server.URL, path, &DownloadOptions{
+		Segments: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(path); !bytes.Equal(data, content) {
+		t.Error("wrong content")
+	}
+
+	if closed := <-probed; !closed {
+		t.Error("the probe should be closed without reading the body")
+	}
+}
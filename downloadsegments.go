@@ -0,0 +1,227 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Segments are not smaller than this, small files have fewer segments.
+const minDownloadSegmentSize = 64 << 10
+
+// Default retries of a failed segment.
+const defaultSegmentRetries = 3
+
+// A range of a segmented download.
+type downloadSegment struct {
+	// Inclusive.
+	start, end int64
+
+	// Bytes written, a retry starts from here.
+	written int64
+}
+
+// Split the size into n segments.
+func splitSegments(size int64, n int) []*downloadSegment {
+	if max := (size + minDownloadSegmentSize - 1) / minDownloadSegmentSize; int64(n) > max {
+		n = int(max)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	segments := make([]*downloadSegment, n)
+	segmentSize := size / int64(n)
+	for i := range segments {
+		segments[i] = &downloadSegment{
+			start: int64(i) * segmentSize,
+			end:   int64(i+1)*segmentSize - 1,
+		}
+	}
+	segments[n-1].end = size - 1
+
+	return segments
+}
+
+// Write at the offset of a file.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (this *offsetWriter) Write(p []byte) (int, error) {
+	n, err := this.f.WriteAt(p, this.offset)
+	this.offset += int64(n)
+
+	return n, err
+}
+
+// Download ranges concurrently into a preallocated partial file, ok is false
+// if the server does not support ranges.
+func (this *HttpClient) downloadSegments(ctx context.Context, url string, path string,
+	options *DownloadOptions, timeout time.Duration, checksum *downloadChecksum,
+	restore func()) (res *Response, ok bool, err error) {
+	partPath := path + ".part"
+	metaPath := partPath + ".json"
+
+	// probe with the first byte
+	res, err = this.
+		WithOption(OPT_CONTEXT, ctx).
+		Do("GET", url, map[string]string{
+			"Accept-Encoding": "identity",
+			"Range":           "bytes=0-0",
+		}, nil)
+	if err != nil {
+		return res, false, err
+	}
+
+	// the whole file might be sent if ranges are not supported
+	if res.StatusCode != 206 {
+		res.Body.Close()
+		return res, false, nil
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	_, _, size, err := parseContentRange(res.Header.Get("Content-Range"))
+	if err != nil || size <= 0 {
+		return res, false, nil
+	}
+
+	meta := &downloadMeta{
+		URL:          url,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Segmented:    true,
+	}
+	if err := writeDownloadMeta(metaPath, meta); err != nil {
+		return res, true, err
+	}
+
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return res, true, err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return res, true, err
+	}
+
+	retries := options.SegmentRetries
+	if retries <= 0 {
+		retries = defaultSegmentRetries
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for _, segment := range splitSegments(size, options.Segments) {
+		wg.Add(1)
+		go func(segment *downloadSegment) {
+			defer wg.Done()
+
+			for attempt := 0; ; attempt++ {
+				err := this.downloadSegment(ctx, url, f, segment, meta.validator(), timeout, restore)
+				if err == nil {
+					return
+				}
+
+				if attempt >= retries || ctx.Err() != nil {
+					// the first error cancels other segments
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}(segment)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		f.Close()
+		os.Remove(partPath)
+		os.Remove(metaPath)
+		return res, true, firstErr
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return res, true, err
+	}
+
+	if info.Size() != size {
+		f.Close()
+		return res, true, fmt.Errorf("download: got %d bytes, expected %d", info.Size(), size)
+	}
+
+	return res, true, finishDownload(partPath, metaPath, path, f, checksum)
+}
+
+// Download the rest of a segment once.
+func (this *HttpClient) downloadSegment(ctx context.Context, url string, f *os.File,
+	segment *downloadSegment, validator string, timeout time.Duration,
+	restore func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	timer := newStallTimer(timeout, cancel)
+	defer timer.stop()
+
+	start := segment.start + segment.written
+	headers := map[string]string{
+		"Accept-Encoding": "identity",
+		"Range":           fmt.Sprintf("bytes=%d-%d", start, segment.end),
+	}
+	if validator != "" {
+		headers["If-Range"] = validator
+	}
+
+	restore()
+	res, err := this.
+		WithOption(OPT_CONTEXT, ctx).
+		withoutTimeout().
+		Do("GET", url, headers, nil)
+	if err != nil {
+		return timer.check(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 206 {
+		return fmt.Errorf("download: unexpected status %s", res.Status)
+	}
+
+	rangeStart, _, _, err := parseContentRange(res.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if rangeStart != start {
+		return fmt.Errorf("download: unexpected range start %d, expected %d", rangeStart, start)
+	}
+
+	n, err := io.Copy(&offsetWriter{f, start},
+		io.LimitReader(&stallReader{res.Body, timer}, segment.end-start+1))
+	segment.written += n
+	if err != nil {
+		return timer.check(err)
+	}
+
+	if remaining := segment.end - segment.start + 1 - segment.written; remaining > 0 {
+		return fmt.Errorf("download: segment %d-%d is short of %d bytes", segment.start, segment.end, remaining)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@
 package httpclient
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -15,6 +16,8 @@ const (
 	ERR_DEFAULT
 	ERR_TIMEOUT
 	ERR_REDIRECT_POLICY
+	ERR_OAUTH2
+	ERR_CIRCUIT_OPEN
 )
 
 // Custom error
@@ -33,7 +36,8 @@ func getErrorCode(err error) int {
 		return 0
 	}
 
-	if e, ok := err.(*Error); ok {
+	var e *Error
+	if errors.As(err, &e) {
 		return e.Code
 	}
 
@@ -77,3 +81,8 @@ func IsRedirectError(err error) bool {
 
 	return false
 }
+
+// Check an error of an open circuit, see OPT_CIRCUIT_BREAKER.
+func IsCircuitOpenError(err error) bool {
+	return getErrorCode(err) == ERR_CIRCUIT_OPEN
+}
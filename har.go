@@ -0,0 +1,535 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Default bytes of a body to record, see HARRecorder.
+const defaultHARMaxBodySize = 1 << 20
+
+// Records requests into HTTP Archive(HAR 1.2), see OPT_HAR. Every request
+// sent(including redirects and retries) is an entry. It can be shared by
+// clients.
+type HARRecorder struct {
+	// Maximum bytes of a body to record, default to 1MB, negative to not
+	// record bodies.
+	MaxBodySize int
+
+	lock    sync.Mutex
+	entries []*harEntry
+}
+
+// Create a HAR recorder.
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{}
+}
+
+type harLog struct {
+	Log harLogContent `json:"log"`
+}
+
+type harLogContent struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+
+	started time.Time
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Durations in milliseconds, -1 if it does not apply.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+func harDuration(start time.Time, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return -1
+	}
+
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []harNameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			result = append(result, harNameValue{name, value})
+		}
+	}
+
+	return result
+}
+
+func harCookies(cookies []*http.Cookie) []harCookie {
+	result := []harCookie{}
+	for _, cookie := range cookies {
+		c := harCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HTTPOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			c.Expires = cookie.Expires.UTC().Format(time.RFC3339)
+		}
+		result = append(result, c)
+	}
+
+	return result
+}
+
+// Text of a body, binary data is encoded with base64.
+func harText(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+// A buffer keeps up to max bytes of the data written, it's written and read by
+// different goroutines.
+type harBuffer struct {
+	lock      sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	size      int64
+	truncated bool
+}
+
+func (this *harBuffer) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.size += int64(len(p))
+	if remaining := this.max - this.buf.Len(); remaining < len(p) {
+		if remaining > 0 {
+			this.buf.Write(p[:remaining])
+		}
+		this.truncated = this.truncated || len(p) > 0
+	} else {
+		this.buf.Write(p)
+	}
+
+	return len(p), nil
+}
+
+func (this *harBuffer) data() ([]byte, int64, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return append([]byte(nil), this.buf.Bytes()...), this.size, this.truncated
+}
+
+// A body is written to a buffer as it's read.
+type harBody struct {
+	io.ReadCloser
+	buf  *harBuffer
+	once sync.Once
+	done func()
+}
+
+func (this *harBody) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+	this.buf.Write(p[:n])
+	if err != nil {
+		this.once.Do(this.done)
+	}
+
+	return n, err
+}
+
+func (this *harBody) Close() error {
+	this.once.Do(this.done)
+
+	return this.ReadCloser.Close()
+}
+
+func (this *HARRecorder) maxBodySize() int {
+	if this.MaxBodySize == 0 {
+		return defaultHARMaxBodySize
+	}
+
+	if this.MaxBodySize < 0 {
+		return 0
+	}
+
+	return this.MaxBodySize
+}
+
+// A RoundTripper records requests into a HARRecorder.
+type harTransport struct {
+	transport http.RoundTripper
+	recorder  *HARRecorder
+}
+
+// Implement http.RoundTripper.
+func (this *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := this.recorder
+	timing := newTimingRecorder()
+	entry := &harEntry{
+		StartedDateTime: timing.start.Format(time.RFC3339Nano),
+		started:         timing.start,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+		},
+	}
+
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range query[name] {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, value})
+		}
+	}
+
+	req = req.Clone(httptrace.WithClientTrace(req.Context(), timing.trace()))
+
+	var reqBuf *harBuffer
+	if req.Body != nil && req.Body != http.NoBody {
+		reqBuf = &harBuffer{
+			max: recorder.maxBodySize(),
+		}
+		req.Body = &harBody{
+			ReadCloser: req.Body,
+			buf:        reqBuf,
+			done:       func() {},
+		}
+	}
+
+	res, err := this.transport.RoundTrip(req)
+
+	if reqBuf != nil {
+		data, size, truncated := reqBuf.data()
+		entry.Request.BodySize = size
+		entry.Request.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+		}
+		if recorder.maxBodySize() > 0 {
+			entry.Request.PostData.Text, _ = harText(data)
+		}
+		if truncated {
+			entry.Request.PostData.Comment = "truncated"
+		}
+	}
+
+	if err != nil {
+		entry.Comment = err.Error()
+		entry.Response = harResponse{
+			Cookies:     []harCookie{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		this.finish(entry, timing, time.Now())
+		return nil, err
+	}
+
+	entry.Response = harResponse{
+		Status:      res.StatusCode,
+		StatusText:  http.StatusText(res.StatusCode),
+		HTTPVersion: res.Proto,
+		Cookies:     harCookies(res.Cookies()),
+		Headers:     harHeaders(res.Header),
+		Content: harContent{
+			MimeType: res.Header.Get("Content-Type"),
+		},
+		RedirectURL: res.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+
+	// the entry is recorded now, the body is added when it's read or closed
+	recorder.lock.Lock()
+	recorder.entries = append(recorder.entries, entry)
+	recorder.lock.Unlock()
+
+	resBuf := &harBuffer{
+		max: recorder.maxBodySize(),
+	}
+	encoding := res.Header.Get("Content-Encoding")
+	res.Body = &harBody{
+		ReadCloser: res.Body,
+		buf:        resBuf,
+		done: func() {
+			end := time.Now()
+			data, size, truncated := resBuf.data()
+
+			recorder.lock.Lock()
+			defer recorder.lock.Unlock()
+
+			entry.Response.BodySize = size
+			entry.Response.Content.Size = size
+			if truncated {
+				entry.Response.Content.Comment = "truncated"
+			} else if encoding == "gzip" && len(data) > 0 {
+				// the content is decoded
+				if reader, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+					if decoded, err := ioutil.ReadAll(reader); err == nil {
+						data = decoded
+						entry.Response.Content.Size = int64(len(decoded))
+					}
+				}
+			}
+
+			if len(data) > 0 {
+				entry.Response.Content.Text, entry.Response.Content.Encoding = harText(data)
+			}
+
+			this.setTimings(entry, timing, end)
+		},
+	}
+
+	return res, nil
+}
+
+// Record an entry without a response.
+func (this *harTransport) finish(entry *harEntry, timing *timingRecorder, end time.Time) {
+	this.recorder.lock.Lock()
+	defer this.recorder.lock.Unlock()
+
+	this.setTimings(entry, timing, end)
+	this.recorder.entries = append(this.recorder.entries, entry)
+}
+
+// Must be called with the lock of the recorder.
+func (this *harTransport) setTimings(entry *harEntry, timing *timingRecorder, end time.Time) {
+	timing.lock.Lock()
+	defer timing.lock.Unlock()
+
+	connectEnd := timing.connectDone
+	if !timing.tlsDone.IsZero() {
+		connectEnd = timing.tlsDone
+	}
+
+	receiveStart := timing.firstByte
+	if receiveStart.IsZero() {
+		receiveStart = end
+	}
+
+	entry.Timings = harTimings{
+		Blocked: -1,
+		DNS:     harDuration(timing.dnsStart, timing.dnsDone),
+		Connect: harDuration(timing.connectStart, connectEnd),
+		SSL:     harDuration(timing.tlsStart, timing.tlsDone),
+		Send:    harDuration(timing.gotConn, timing.wroteRequest),
+		Wait:    harDuration(timing.wroteRequest, timing.firstByte),
+		Receive: harDuration(receiveStart, end),
+	}
+	entry.Time = harDuration(timing.start, end)
+
+	if timing.remoteAddr != "" {
+		if host, _, err := net.SplitHostPort(timing.remoteAddr); err == nil {
+			entry.ServerIPAddress = host
+		}
+	}
+	if timing.localAddr != "" {
+		if _, port, err := net.SplitHostPort(timing.localAddr); err == nil {
+			entry.Connection = port
+		}
+	}
+
+	// HAR requires non-negative send, wait and receive
+	if entry.Timings.Send < 0 {
+		entry.Timings.Send = 0
+	}
+	if entry.Timings.Wait < 0 {
+		entry.Timings.Wait = 0
+	}
+	if entry.Timings.Receive < 0 {
+		entry.Timings.Receive = 0
+	}
+}
+
+// Write the HAR of requests recorded, entries are sorted by the start time.
+func (this *HARRecorder) Write(w io.Writer) error {
+	this.lock.Lock()
+	entries := append([]*harEntry(nil), this.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].started.Before(entries[j].started)
+	})
+
+	data, err := json.MarshalIndent(&harLog{
+		Log: harLogContent{
+			Version: "1.2",
+			Creator: harCreator{
+				Name:    "go-httpclient",
+				Version: VERSION,
+			},
+			Entries: entries,
+		},
+	}, "", "  ")
+	this.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+// Write the HAR to a file.
+func (this *HARRecorder) WriteFile(path string) error {
+	// write to a temporary file, so that the file is never partial
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := this.Write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Number of requests recorded.
+func (this *HARRecorder) Len() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return len(this.entries)
+}
+
+// Remove requests recorded.
+func (this *HARRecorder) Reset() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.entries = nil
+}
+
+// Prepare the HAR recorder with OPT_HAR.
+func prepareHARRecorder(options map[int]interface{}) (*HARRecorder, error) {
+	recorder_, ok := options[OPT_HAR]
+	if !ok || recorder_ == nil {
+		return nil, nil
+	}
+
+	recorder, ok := recorder_.(*HARRecorder)
+	if !ok {
+		return nil, fmt.Errorf("OPT_HAR must be *httpclient.HARRecorder")
+	}
+
+	return recorder, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHAR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target?b=2", 307)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	recorder := NewHARRecorder()
+	res, err := NewHttpClient().
+		WithOption(OPT_HAR, recorder).
+		WithCookie(&http.Cookie{Name: "a", Value: "1"}).
+		PostJson(server.URL+"/redirect?a=1", map[string]string{"name": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	if recorder.Len() != 2 {
+		t.Fatal("wrong number of entries:", recorder.Len())
+	}
+
+	entries := recorder.entries
+	redirect, target := entries[0], entries[1]
+
+	if redirect.Request.Method != "POST" || redirect.Request.URL != server.URL+"/redirect?a=1" {
+		t.Error("wrong request:", redirect.Request.Method, redirect.Request.URL)
+	}
+	if len(redirect.Request.QueryString) != 1 || redirect.Request.QueryString[0] != (harNameValue{"a", "1"}) {
+		t.Error("wrong query string:", redirect.Request.QueryString)
+	}
+	if len(redirect.Request.Cookies) != 1 || redirect.Request.Cookies[0].Name != "a" {
+		t.Error("wrong request cookies:", redirect.Request.Cookies)
+	}
+	if redirect.Request.PostData == nil || redirect.Request.PostData.Text != `{"name":"value"}` ||
+		redirect.Request.PostData.MimeType != "application/json" {
+		t.Error("wrong post data:", redirect.Request.PostData)
+	}
+	if redirect.Response.Status != 307 || redirect.Response.RedirectURL != "/target?b=2" {
+		t.Error("wrong redirect response:", redirect.Response.Status, redirect.Response.RedirectURL)
+	}
+	if redirect.ServerIPAddress != "127.0.0.1" {
+		t.Error("wrong server ip address:", redirect.ServerIPAddress)
+	}
+
+	if target.Request.URL != server.URL+"/target?b=2" || target.Request.PostData == nil {
+		t.Error("wrong redirected request:", target.Request.URL)
+	}
+	if target.Response.Content.Text != `{"ok":true}` || target.Response.Content.Size != 11 ||
+		target.Response.Content.MimeType != "application/json" {
+		t.Error("wrong content:", target.Response.Content)
+	}
+	if len(target.Response.Cookies) != 1 || target.Response.Cookies[0].Name != "session" ||
+		target.Response.Cookies[0].Value != "abc" {
+		t.Error("wrong response cookies:", target.Response.Cookies)
+	}
+	if target.Time <= 0 || target.Timings.Blocked != -1 || target.Timings.Wait < 0 {
+		t.Error("wrong timings:", target.Time, target.Timings)
+	}
+
+	recorder.Reset()
+	if recorder.Len() != 0 {
+		t.Error("entries should be reset")
+	}
+}
+
+func TestHARMaxBodySize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0xfe, 0xfd, 0xfc, 0xfb, 0xfa})
+	}))
+	defer server.Close()
+
+	recorder := &HARRecorder{
+		MaxBodySize: 4,
+	}
+	res, err := NewHttpClient().
+		WithOption(OPT_HAR, recorder).
+		Post(server.URL, map[string]string{"a": "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	entry := recorder.entries[0]
+	if entry.Request.BodySize != 8 || entry.Request.PostData.Text != "a=12" ||
+		entry.Request.PostData.Comment != "truncated" {
+		t.Error("wrong post data:", entry.Request.BodySize, entry.Request.PostData)
+	}
+	if entry.Response.Content.Size != 6 || entry.Response.Content.Text != "//79/A==" ||
+		entry.Response.Content.Encoding != "base64" || entry.Response.Content.Comment != "truncated" {
+		t.Error("wrong content:", entry.Response.Content)
+	}
+
+	recorder = &HARRecorder{
+		MaxBodySize: -1,
+	}
+	res, err = NewHttpClient().
+		WithOption(OPT_HAR, recorder).
+		Post(server.URL, map[string]string{"a": "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	entry = recorder.entries[0]
+	if entry.Request.PostData.Text != "" || entry.Response.Content.Text != "" ||
+		entry.Response.Content.Size != 6 {
+		t.Error("bodies should not be recorded:", entry.Request.PostData, entry.Response.Content)
+	}
+}
+
+func TestHARWriteFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	recorder := NewHARRecorder()
+	client := NewHttpClient().Defaults(Map{
+		OPT_HAR: recorder,
+	})
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.ReadAll()
+	}
+
+	dir, err := ioutil.TempDir("", "har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "traffic.har")
+	if err := recorder.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var har struct {
+		Log struct {
+			Version string
+			Creator struct {
+				Name string
+			}
+			Entries []map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatal(err)
+	}
+
+	if har.Log.Version != "1.2" || har.Log.Creator.Name != "go-httpclient" || len(har.Log.Entries) != 2 {
+		t.Error("wrong har:", string(data))
+	}
+	for _, field := range []string{"startedDateTime", "time", "request", "response", "cache", "timings"} {
+		if _, ok := har.Log.Entries[0][field]; !ok {
+			t.Error("entry should have", field)
+		}
+	}
+	if !strings.Contains(string(data), `"text": "hello"`) {
+		t.Error("content should be recorded:", string(data))
+	}
+}
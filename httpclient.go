@@ -15,12 +15,12 @@ import (
 
 	"io"
 	"io/ioutil"
+	"os"
 	"sync"
 
 	"net"
 	"net/http"
-	"net/http/cookiejar"
-	"net/http/httputil"
+	"net/http/httptrace"
 	"net/url"
 
 	"crypto/tls"
@@ -68,29 +68,90 @@ const (
 	OPT_CONTEXT
 
 	OPT_BEFORE_REQUEST_FUNC
+
+	OPT_USERPWD
+	OPT_HTTPAUTH
+	OPT_XOAUTH2_BEARER
+	OPT_OAUTH2
+	OPT_AWS_SIGV4
+	OPT_AWS_CREDENTIALS
+	OPT_AWS_UNSIGNED_PAYLOAD
+	OPT_SIGNER
+	OPT_NETRC
+	OPT_NETRC_FILE
+	OPT_COOKIEFILE
+	OPT_PUBLIC_SUFFIX_LIST
+	OPT_COOKIE_POLICY
+	OPT_COOKIE
+	OPT_CACHE
+	OPT_RATE_LIMIT
+	OPT_CIRCUIT_BREAKER
+	OPT_PROGRESSFUNCTION
+	OPT_MAX_SEND_SPEED
+	OPT_MAX_RECV_SPEED
+	OPT_REDIRECT_HEADERS
+	OPT_UNRESTRICTED_AUTH
+	OPT_REDIRECT_DOWNGRADE
+	OPT_POSTREDIR
+	OPT_LOGGER
+	OPT_LOG_BODY_LIMIT
+	OPT_LOG_REDACT
+	OPT_TRACE
+	OPT_TRACE_ASCII
+	OPT_HAR
 )
 
 // String map of options
 var CONST = map[string]int{
-	"OPT_AUTOREFERER":         OPT_AUTOREFERER,
-	"OPT_FOLLOWLOCATION":      OPT_FOLLOWLOCATION,
-	"OPT_CONNECTTIMEOUT":      OPT_CONNECTTIMEOUT,
-	"OPT_CONNECTTIMEOUT_MS":   OPT_CONNECTTIMEOUT_MS,
-	"OPT_MAXREDIRS":           OPT_MAXREDIRS,
-	"OPT_PROXYTYPE":           OPT_PROXYTYPE,
-	"OPT_TIMEOUT":             OPT_TIMEOUT,
-	"OPT_TIMEOUT_MS":          OPT_TIMEOUT_MS,
-	"OPT_COOKIEJAR":           OPT_COOKIEJAR,
-	"OPT_INTERFACE":           OPT_INTERFACE,
-	"OPT_PROXY":               OPT_PROXY,
-	"OPT_REFERER":             OPT_REFERER,
-	"OPT_USERAGENT":           OPT_USERAGENT,
-	"OPT_REDIRECT_POLICY":     OPT_REDIRECT_POLICY,
-	"OPT_PROXY_FUNC":          OPT_PROXY_FUNC,
-	"OPT_DEBUG":               OPT_DEBUG,
-	"OPT_UNSAFE_TLS":          OPT_UNSAFE_TLS,
-	"OPT_CONTEXT":             OPT_CONTEXT,
-	"OPT_BEFORE_REQUEST_FUNC": OPT_BEFORE_REQUEST_FUNC,
+	"OPT_AUTOREFERER":          OPT_AUTOREFERER,
+	"OPT_FOLLOWLOCATION":       OPT_FOLLOWLOCATION,
+	"OPT_CONNECTTIMEOUT":       OPT_CONNECTTIMEOUT,
+	"OPT_CONNECTTIMEOUT_MS":    OPT_CONNECTTIMEOUT_MS,
+	"OPT_MAXREDIRS":            OPT_MAXREDIRS,
+	"OPT_PROXYTYPE":            OPT_PROXYTYPE,
+	"OPT_TIMEOUT":              OPT_TIMEOUT,
+	"OPT_TIMEOUT_MS":           OPT_TIMEOUT_MS,
+	"OPT_COOKIEJAR":            OPT_COOKIEJAR,
+	"OPT_INTERFACE":            OPT_INTERFACE,
+	"OPT_PROXY":                OPT_PROXY,
+	"OPT_REFERER":              OPT_REFERER,
+	"OPT_USERAGENT":            OPT_USERAGENT,
+	"OPT_REDIRECT_POLICY":      OPT_REDIRECT_POLICY,
+	"OPT_PROXY_FUNC":           OPT_PROXY_FUNC,
+	"OPT_DEBUG":                OPT_DEBUG,
+	"OPT_UNSAFE_TLS":           OPT_UNSAFE_TLS,
+	"OPT_CONTEXT":              OPT_CONTEXT,
+	"OPT_BEFORE_REQUEST_FUNC":  OPT_BEFORE_REQUEST_FUNC,
+	"OPT_USERPWD":              OPT_USERPWD,
+	"OPT_HTTPAUTH":             OPT_HTTPAUTH,
+	"OPT_XOAUTH2_BEARER":       OPT_XOAUTH2_BEARER,
+	"OPT_OAUTH2":               OPT_OAUTH2,
+	"OPT_AWS_SIGV4":            OPT_AWS_SIGV4,
+	"OPT_AWS_CREDENTIALS":      OPT_AWS_CREDENTIALS,
+	"OPT_AWS_UNSIGNED_PAYLOAD": OPT_AWS_UNSIGNED_PAYLOAD,
+	"OPT_SIGNER":               OPT_SIGNER,
+	"OPT_NETRC":                OPT_NETRC,
+	"OPT_NETRC_FILE":           OPT_NETRC_FILE,
+	"OPT_COOKIEFILE":           OPT_COOKIEFILE,
+	"OPT_PUBLIC_SUFFIX_LIST":   OPT_PUBLIC_SUFFIX_LIST,
+	"OPT_COOKIE_POLICY":        OPT_COOKIE_POLICY,
+	"OPT_COOKIE":               OPT_COOKIE,
+	"OPT_CACHE":                OPT_CACHE,
+	"OPT_RATE_LIMIT":           OPT_RATE_LIMIT,
+	"OPT_CIRCUIT_BREAKER":      OPT_CIRCUIT_BREAKER,
+	"OPT_PROGRESSFUNCTION":     OPT_PROGRESSFUNCTION,
+	"OPT_MAX_SEND_SPEED":       OPT_MAX_SEND_SPEED,
+	"OPT_MAX_RECV_SPEED":       OPT_MAX_RECV_SPEED,
+	"OPT_REDIRECT_HEADERS":     OPT_REDIRECT_HEADERS,
+	"OPT_UNRESTRICTED_AUTH":    OPT_UNRESTRICTED_AUTH,
+	"OPT_REDIRECT_DOWNGRADE":   OPT_REDIRECT_DOWNGRADE,
+	"OPT_POSTREDIR":            OPT_POSTREDIR,
+	"OPT_LOGGER":               OPT_LOGGER,
+	"OPT_LOG_BODY_LIMIT":       OPT_LOG_BODY_LIMIT,
+	"OPT_LOG_REDACT":           OPT_LOG_REDACT,
+	"OPT_TRACE":                OPT_TRACE,
+	"OPT_TRACE_ASCII":          OPT_TRACE_ASCII,
+	"OPT_HAR":                  OPT_HAR,
 }
 
 // Default options for any clients.
@@ -121,11 +182,61 @@ var transportOptions = []int{
 // these options during a request.
 var jarOptions = []int{
 	OPT_COOKIEJAR,
+	OPT_COOKIEFILE,
+	OPT_PUBLIC_SUFFIX_LIST,
+	OPT_COOKIE_POLICY,
 }
 
 // Thin wrapper of http.Response(can also be used as http.Response).
 type Response struct {
 	*http.Response
+
+	// Whether the response is from the cache, see OPT_CACHE.
+	CacheStatus int
+
+	// Redirects followed to get the response, in order.
+	Redirects []*Redirect
+
+	// Timings of the request, the total is known after the body is read or
+	// closed.
+	Timings *Timings
+}
+
+// State of a request shared with transports, it's reported by the response.
+type requestState struct {
+	cacheStatus int
+	redirects   []*Redirect
+
+	// Whether credentials are added to the current request(by
+	// authentication or signing).
+	credentials bool
+}
+
+type requestStateKey struct{}
+
+// The state of the request, a new state is returned if the request is not
+// sent by the client.
+func getRequestState(req *http.Request) *requestState {
+	if state, ok := req.Context().Value(requestStateKey{}).(*requestState); ok {
+		return state
+	}
+
+	return &requestState{}
+}
+
+// The url of the last request, after redirects(like curl's
+// %{url_effective}).
+func (this *Response) EffectiveURL() string {
+	if this.Response == nil || this.Request == nil {
+		return ""
+	}
+
+	return this.Request.URL.String()
+}
+
+// Number of redirects followed(like curl's %{num_redirects}).
+func (this *Response) NumRedirects() int {
+	return len(this.Redirects)
 }
 
 // Read response body into a byte slice.
@@ -183,9 +294,30 @@ func prepareRequest(method string, url_ string, headers map[string]string,
 		req.Header.Set(k, v)
 	}
 
+	// OPT_COOKIE
+	if cookie_, ok := options[OPT_COOKIE]; ok {
+		cookie, ok := cookie_.(string)
+		if !ok {
+			return nil, fmt.Errorf("OPT_COOKIE must be string")
+		}
+
+		for _, c := range parseCookieString(cookie) {
+			req.AddCookie(c)
+		}
+	}
+
 	return req, nil
 }
 
+// Parse cookies in the form of the Cookie header("a=1; b=2").
+func parseCookieString(s string) []*http.Cookie {
+	req := &http.Request{
+		Header: http.Header{"Cookie": {s}},
+	}
+
+	return req.Cookies()
+}
+
 func prepareTimeout(options map[int]interface{}) (time.Duration, error) {
 	var timeout time.Duration
 
@@ -252,23 +384,12 @@ func prepareTransport(options map[int]interface{}) (http.RoundTripper, error) {
 		return nil, err
 	}
 
-	transport.Dial = func(network, addr string) (net.Conn, error) {
-		var conn net.Conn
-		var err error
-		if connectTimeout > 0 {
-			conn, err = net.DialTimeout(network, addr, connectTimeout)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			conn, err = net.Dial(network, addr)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		return conn, nil
+	// dial with the context of the request, so that dials are traced(see
+	// Timings) and canceled with the request
+	dialer := &net.Dialer{
+		Timeout: connectTimeout,
 	}
+	transport.DialContext = dialer.DialContext
 
 	// proxy
 	if proxyFunc_, ok := options[OPT_PROXY_FUNC]; ok {
@@ -335,74 +456,27 @@ func prepareTransport(options map[int]interface{}) (http.RoundTripper, error) {
 	return transport, nil
 }
 
-// Prepare a redirect policy.
-func prepareRedirect(options map[int]interface{}) (func(req *http.Request, via []*http.Request) error, error) {
-	var redirectPolicy func(req *http.Request, via []*http.Request) error
-
-	if redirectPolicy_, ok := options[OPT_REDIRECT_POLICY]; ok {
-		if redirectPolicy, ok = redirectPolicy_.(func(*http.Request, []*http.Request) error); !ok {
-			return nil, fmt.Errorf("OPT_REDIRECT_POLICY is not a desired function")
-		}
-	} else {
-		var followlocation bool
-		if followlocation_, ok := options[OPT_FOLLOWLOCATION]; ok {
-			if followlocation, ok = followlocation_.(bool); !ok {
-				return nil, fmt.Errorf("OPT_FOLLOWLOCATION must be bool")
-			}
-		}
-
-		var maxredirs int
-		if maxredirs_, ok := options[OPT_MAXREDIRS]; ok {
-			if maxredirs, ok = maxredirs_.(int); !ok {
-				return nil, fmt.Errorf("OPT_MAXREDIRS must be int")
-			}
-		}
-
-		redirectPolicy = func(req *http.Request, via []*http.Request) error {
-			// no follow
-			if !followlocation || maxredirs <= 0 {
-				return &Error{
-					Code:    ERR_REDIRECT_POLICY,
-					Message: fmt.Sprintf("redirect not allowed"),
-				}
-			}
-
-			if len(via) >= maxredirs {
-				return &Error{
-					Code:    ERR_REDIRECT_POLICY,
-					Message: fmt.Sprintf("stopped after %d redirects", len(via)),
-				}
-			}
-
-			last := via[len(via)-1]
-			// keep necessary headers
-			// TODO: pass all headers or add other headers?
-			if useragent := last.Header.Get("User-Agent"); useragent != "" {
-				req.Header.Set("User-Agent", useragent)
-			}
-
-			return nil
-		}
-	}
-
-	return redirectPolicy, nil
-}
-
 // Prepare a cookie jar.
+//
+// OPT_COOKIEJAR can be a bool, an http.CookieJar or the path to save cookies
+// to(the default jar is used). Cookies of OPT_COOKIEFILE are loaded into the
+// jar, a missing file is ignored.
 func prepareJar(options map[int]interface{}) (http.CookieJar, error) {
+	list, err := preparePublicSuffixList(options)
+	if err != nil {
+		return nil, err
+	}
+
 	var jar http.CookieJar
-	var err error
 	if optCookieJar_, ok := options[OPT_COOKIEJAR]; ok {
 		// is bool
 		if optCookieJar, ok := optCookieJar_.(bool); ok {
 			// default jar
 			if optCookieJar {
-				// TODO: PublicSuffixList
-				jar, err = cookiejar.New(nil)
-				if err != nil {
-					return nil, err
-				}
+				jar = NewJarWithPublicSuffixList(list)
 			}
+		} else if _, ok := optCookieJar_.(string); ok {
+			jar = NewJarWithPublicSuffixList(list)
 		} else if optCookieJar, ok := optCookieJar_.(http.CookieJar); ok {
 			jar = optCookieJar
 		} else {
@@ -410,9 +484,219 @@ func prepareJar(options map[int]interface{}) (http.CookieJar, error) {
 		}
 	}
 
+	if cookieFile_, ok := options[OPT_COOKIEFILE]; ok {
+		cookieFile, ok := cookieFile_.(string)
+		if !ok {
+			return nil, fmt.Errorf("OPT_COOKIEFILE must be string")
+		}
+
+		// cookie file enables the cookie engine
+		if jar == nil {
+			jar = NewJarWithPublicSuffixList(list)
+		}
+
+		j, ok := jar.(*Jar)
+		if !ok {
+			return nil, fmt.Errorf("OPT_COOKIEFILE only works with the default cookiejar")
+		}
+
+		if err := j.LoadFile(cookieFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	policy, err := prepareCookiePolicy(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy != nil && jar != nil {
+		j, ok := jar.(*Jar)
+		if !ok {
+			return nil, fmt.Errorf("OPT_COOKIE_POLICY only works with the default cookiejar")
+		}
+		j.SetPolicy(policy)
+	}
+
 	return jar, nil
 }
 
+// Wrap the transport with features of the current request(signing,
+// authentication, etc.), they apply to every request sent through it,
+// including redirects.
+func wrapTransport(transport http.RoundTripper, req *http.Request,
+	options map[int]interface{}) (http.RoundTripper, error) {
+	base := transport
+
+	// Tracing is closest to the wire.
+	tracer, err := prepareTracer(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if tracer != nil {
+		transport = &traceTransport{
+			transport: transport,
+			tracer:    tracer,
+		}
+	}
+
+	// HAR is recorded close to the wire, each request sent is an entry.
+	harRecorder, err := prepareHARRecorder(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if harRecorder != nil {
+		transport = &harTransport{
+			transport: transport,
+			recorder:  harRecorder,
+		}
+	}
+
+	// Logging is close to the wire, so that requests are logged as they are
+	// sent.
+	logConfig, err := prepareLogger(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if logConfig != nil {
+		transport = &logTransport{
+			transport: transport,
+			config:    logConfig,
+		}
+	}
+
+	// Progress and speed limits are close to the wire, bodies read by
+	// signers are not counted.
+	progress, err := prepareProgress(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if progress != nil {
+		transport = &progressTransport{
+			transport: transport,
+			progress:  progress,
+		}
+	}
+
+	send, recv, err := prepareSpeedLimiters(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if send != nil || recv != nil {
+		transport = &speedLimitTransport{
+			transport: transport,
+			send:      send,
+			recv:      recv,
+		}
+	}
+
+	// Signers wrap the wire-level features above, and sit inside OAuth2 and
+	// authentication, so that they see the final headers.
+	signer, err := prepareSigner(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if signer != nil {
+		unrestricted, _ := options[OPT_UNRESTRICTED_AUTH].(bool)
+		transport = &signerTransport{
+			transport:    transport,
+			signer:       signer,
+			host:         req.URL.Host,
+			unrestricted: unrestricted,
+		}
+	}
+
+	awsSigner, err := prepareAWSSigner(req.URL, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if awsSigner != nil {
+		unrestricted, _ := options[OPT_UNRESTRICTED_AUTH].(bool)
+		transport = &awsSigV4Transport{
+			transport:    transport,
+			signer:       awsSigner,
+			host:         req.URL.Host,
+			unrestricted: unrestricted,
+		}
+	}
+
+	tokenSource, err := prepareTokenSource(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenSource != nil {
+		unrestricted, _ := options[OPT_UNRESTRICTED_AUTH].(bool)
+		transport = &oauth2Transport{
+			transport:    transport,
+			base:         base,
+			source:       tokenSource,
+			host:         req.URL.Host,
+			unrestricted: unrestricted,
+		}
+	}
+
+	auth, err := prepareAuth(req.URL, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth != nil {
+		transport = &authTransport{
+			transport: transport,
+			auth:      auth,
+		}
+	}
+
+	limiter, err := prepareRateLimiter(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if limiter != nil {
+		transport = &rateLimitTransport{
+			transport: transport,
+			limiter:   limiter,
+		}
+	}
+
+	// Fail fast before waiting for the rate limiter.
+	breaker, err := prepareCircuitBreaker(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if breaker != nil {
+		transport = &circuitBreakerTransport{
+			transport: transport,
+			breaker:   breaker,
+		}
+	}
+
+	// Cache comes last, so that fresh responses are served without touching
+	// the network.
+	cache, err := prepareCache(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil {
+		transport = &cacheTransport{
+			transport: transport,
+			storage:   cache,
+		}
+	}
+
+	return transport, nil
+}
+
 // Create an HTTP client.
 func NewHttpClient() *HttpClient {
 	c := &HttpClient{
@@ -441,6 +725,9 @@ type HttpClient struct {
 	// Cookies of current request.
 	oneTimeCookies []*http.Cookie
 
+	// Cookies of the current request, they are not added to the jar.
+	oneTimeRequestCookies []*http.Cookie
+
 	// Global transport of this client, might be shared between different
 	// requests.
 	transport http.RoundTripper
@@ -455,9 +742,15 @@ type HttpClient struct {
 	// Whether current request should reuse the cookie jar or not.
 	reuseJar bool
 
+	// Whether the timeout of the current request is disabled.
+	noTimeout bool
+
 	// Make requests of one client concurrent safe.
 	lock *sync.Mutex
 
+	// Guard the jar, it's accessed by requests and the cookie API.
+	jarLock sync.Mutex
+
 	withLock bool
 }
 
@@ -500,8 +793,10 @@ func (this *HttpClient) reset() {
 	this.oneTimeOptions = nil
 	this.oneTimeHeaders = nil
 	this.oneTimeCookies = nil
+	this.oneTimeRequestCookies = nil
 	this.reuseTransport = true
 	this.reuseJar = true
+	this.noTimeout = false
 
 	// nil means the Begin has not been called, asume requests are not
 	// concurrent.
@@ -531,6 +826,14 @@ func (this *HttpClient) WithOption(k int, v interface{}) *HttpClient {
 	return this
 }
 
+// Disable the timeout of the current request, unlike setting OPT_TIMEOUT the
+// transport is reused.
+func (this *HttpClient) withoutTimeout() *HttpClient {
+	this.noTimeout = true
+
+	return this
+}
+
 // Temporarily specify multiple options of the current request.
 func (this *HttpClient) WithOptions(m Map) *HttpClient {
 	options, _ := parseMap(m)
@@ -560,13 +863,22 @@ func (this *HttpClient) WithHeaders(m map[string]string) *HttpClient {
 	return this
 }
 
-// Specify cookies of the current request.
+// Specify cookies of the current request, the cookies are also added to the
+// cookie jar, so they are kept for subsequent requests.
 func (this *HttpClient) WithCookie(cookies ...*http.Cookie) *HttpClient {
 	this.oneTimeCookies = append(this.oneTimeCookies, cookies...)
 
 	return this
 }
 
+// Specify cookies which are only sent with the current request(and its
+// redirects to the same domain), the cookie jar is not changed.
+func (this *HttpClient) WithRequestCookie(cookies ...*http.Cookie) *HttpClient {
+	this.oneTimeRequestCookies = append(this.oneTimeRequestCookies, cookies...)
+
+	return this
+}
+
 // Start a request, and get the response.
 //
 // Usually we just need the Get and Post method.
@@ -581,6 +893,7 @@ func (this *HttpClient) Do(method string, url string, headers map[string]string,
 	var err error
 
 	// transport
+	var temporary *http.Transport
 	if this.transport == nil || !this.reuseTransport {
 		transport, err = prepareTransport(options)
 		if err != nil {
@@ -590,25 +903,30 @@ func (this *HttpClient) Do(method string, url string, headers map[string]string,
 
 		if this.reuseTransport {
 			this.transport = transport
+		} else {
+			// the transport is used by this request only
+			temporary, _ = transport.(*http.Transport)
 		}
 	} else {
 		transport = this.transport
 	}
 
 	// jar
+	this.jarLock.Lock()
 	if this.jar == nil || !this.reuseJar {
 		jar, err = prepareJar(options)
-		if err != nil {
-			this.reset()
-			return nil, err
-		}
-
-		if this.reuseJar {
+		if err == nil && this.reuseJar {
 			this.jar = jar
 		}
 	} else {
 		jar = this.jar
 	}
+	this.jarLock.Unlock()
+
+	if err != nil {
+		this.reset()
+		return nil, err
+	}
 
 	// timeout
 	timeout, err := prepareTimeout(options)
@@ -617,6 +935,10 @@ func (this *HttpClient) Do(method string, url string, headers map[string]string,
 		return nil, err
 	}
 
+	if this.noTimeout {
+		timeout = 0
+	}
+
 	redirect, err := prepareRedirect(options)
 	if err != nil {
 		this.reset()
@@ -629,13 +951,10 @@ func (this *HttpClient) Do(method string, url string, headers map[string]string,
 		return nil, err
 	}
 
-	if debugEnabled, ok := options[OPT_DEBUG]; ok {
-		if debugEnabled.(bool) {
-			dump, err := httputil.DumpRequestOut(req, true)
-			if err == nil {
-				fmt.Printf("%s\n", dump)
-			}
-		}
+	transport, err = wrapTransport(transport, req, options)
+	if err != nil {
+		this.reset()
+		return nil, err
 	}
 
 	if jar != nil {
@@ -646,12 +965,27 @@ func (this *HttpClient) Do(method string, url string, headers map[string]string,
 		}
 	}
 
+	for _, cookie := range this.oneTimeRequestCookies {
+		req.AddCookie(cookie)
+	}
+
+	// the jar needs to know the top level request for cookie policies
+	if j, ok := jar.(*Jar); ok {
+		jar = j.forRequest(req)
+	}
+
 	if ctx, ok := options[OPT_CONTEXT]; ok {
 		if c, ok := ctx.(context.Context); ok {
 			req = req.WithContext(c)
 		}
 	}
 
+	state := &requestState{}
+	req = req.WithContext(context.WithValue(req.Context(), requestStateKey{}, state))
+
+	recorder := newTimingRecorder()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), recorder.trace()))
+
 	beforeReqFunc := options[OPT_BEFORE_REQUEST_FUNC]
 
 	// release lock
@@ -672,7 +1006,43 @@ func (this *HttpClient) Do(method string, url string, headers map[string]string,
 
 	res, err := c.Do(req)
 
-	return &Response{res}, err
+	if temporary != nil {
+		if res != nil {
+			res.Body = &closeIdleBody{res.Body, temporary}
+		} else {
+			temporary.CloseIdleConnections()
+		}
+	}
+
+	timings := recorder.timings()
+	if res != nil {
+		res.Body = &timingBody{
+			ReadCloser: res.Body,
+			timings:    timings,
+			recorder:   recorder,
+		}
+	}
+
+	return &Response{
+		Response:    res,
+		CacheStatus: state.cacheStatus,
+		Redirects:   state.redirects,
+		Timings:     timings,
+	}, err
+}
+
+// A response body closes idle connections of the transport when it's closed,
+// the transport is not reused by other requests.
+type closeIdleBody struct {
+	io.ReadCloser
+	transport *http.Transport
+}
+
+func (this *closeIdleBody) Close() error {
+	err := this.ReadCloser.Close()
+	this.transport.CloseIdleConnections()
+
+	return err
 }
 
 // The HEAD request
@@ -838,9 +1208,16 @@ func (this *HttpClient) Patch(url string, params ...map[string]string) (*Respons
 
 // Get cookies of the client jar.
 func (this *HttpClient) Cookies(url_ string) []*http.Cookie {
-	if this.jar != nil {
+	this.jarLock.Lock()
+	jar := this.jar
+	this.jarLock.Unlock()
+
+	if jar != nil {
 		u, _ := url.Parse(url_)
-		return this.jar.Cookies(u)
+		if j, ok := jar.(*Jar); ok {
+			return j.visibleCookies(u)
+		}
+		return jar.Cookies(u)
 	}
 
 	return nil
@@ -867,3 +1244,62 @@ func (this *HttpClient) CookieValue(url_ string, key string) string {
 
 	return ""
 }
+
+// The cookie jar of the client, it's created if no request has been sent.
+func (this *HttpClient) getJar() (http.CookieJar, error) {
+	this.jarLock.Lock()
+	defer this.jarLock.Unlock()
+
+	if this.jar == nil {
+		jar, err := prepareJar(mergeOptions(defaultOptions, this.options))
+		if err != nil {
+			return nil, err
+		}
+		this.jar = jar
+	}
+
+	return this.jar, nil
+}
+
+// The default cookie jar of the client to manage cookies, it's created if no
+// request has been sent. Returns nil if the cookie jar is disabled or
+// customized.
+func (this *HttpClient) CookieJar() *Jar {
+	jar, _ := this.getJar()
+	if j, ok := jar.(*Jar); ok {
+		return j
+	}
+
+	return nil
+}
+
+// Save cookies to the file specified by OPT_COOKIEJAR.
+func (this *HttpClient) SaveCookies() error {
+	options := mergeOptions(defaultOptions, this.options)
+	path, ok := options[OPT_COOKIEJAR].(string)
+	if !ok || path == "" {
+		return nil
+	}
+
+	jar, err := this.getJar()
+	if err != nil {
+		return err
+	}
+
+	if j, ok := jar.(*Jar); ok {
+		return j.SaveFile(path)
+	}
+
+	return nil
+}
+
+// Close the client, cookies are saved to the file specified by OPT_COOKIEJAR.
+func (this *HttpClient) Close() error {
+	err := this.SaveCookies()
+
+	if transport, ok := this.transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+
+	return err
+}
@@ -0,0 +1,521 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A cookie stored in the jar, with all the attributes needed to persist it.
+type JarCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Domain without the leading dot.
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+
+	// The cookie is only sent to the exact domain(no "Domain" attribute was
+	// given).
+	HostOnly bool `json:"hostOnly"`
+
+	Secure   bool          `json:"secure"`
+	HttpOnly bool          `json:"httpOnly"`
+	SameSite http.SameSite `json:"sameSite"`
+
+	// Zero for session cookies.
+	Expires time.Time `json:"expires"`
+
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"lastAccess"`
+
+	// Keep the creation order of cookies created at the same time.
+	seqNum uint64
+}
+
+// Unique id of the cookie in the jar.
+func (this *JarCookie) id() string {
+	return this.Domain + ";" + this.Path + ";" + this.Name
+}
+
+func (this *JarCookie) expired(now time.Time) bool {
+	return !this.Expires.IsZero() && !this.Expires.After(now)
+}
+
+// Should the cookie be sent to the host and path?
+func (this *JarCookie) match(host string, path string, https bool) bool {
+	if this.Secure && !https {
+		return false
+	}
+
+	if this.HostOnly {
+		if host != this.Domain {
+			return false
+		}
+	} else if !domainMatch(host, this.Domain) {
+		return false
+	}
+
+	return pathMatch(path, this.Path)
+}
+
+// A cookie jar which can be enumerated, saved and loaded.
+//
+// Cookies are scoped as described in RFC 6265, cookies for public suffixes
+// (e.g. "co.uk") are rejected.
+type Jar struct {
+	lock sync.Mutex
+
+	psList cookiejar.PublicSuffixList
+	policy *CookiePolicy
+
+	// Cookies grouped by jar key(the registered domain), then by id.
+	entries map[string]map[string]*JarCookie
+
+	nextSeqNum uint64
+}
+
+// Create an empty cookie jar with the built-in public suffix list.
+func NewJar() *Jar {
+	return NewJarWithPublicSuffixList(DefaultPublicSuffixList)
+}
+
+// Create an empty cookie jar with a public suffix list, nil means no public
+// suffix is checked.
+func NewJarWithPublicSuffixList(list cookiejar.PublicSuffixList) *Jar {
+	return &Jar{
+		psList:  list,
+		entries: make(map[string]map[string]*JarCookie),
+	}
+}
+
+// Implement http.CookieJar.
+func (this *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	this.setCookies(u, nil, cookies, time.Now())
+}
+
+// Implement http.CookieJar.
+func (this *Jar) Cookies(u *url.URL) []*http.Cookie {
+	return toHttpCookies(this.cookies(u, nil, time.Now()))
+}
+
+// Cookies of the url for non-HTTP APIs, HttpOnly cookies are hidden if the
+// policy says so.
+func (this *Jar) visibleCookies(u *url.URL) []*http.Cookie {
+	cookies := this.cookies(u, nil, time.Now())
+
+	this.lock.Lock()
+	hideHttpOnly := this.policy != nil && this.policy.HideHttpOnly
+	this.lock.Unlock()
+
+	if hideHttpOnly {
+		cookies = withoutHttpOnly(cookies)
+	}
+
+	return toHttpCookies(cookies)
+}
+
+func toHttpCookies(cookies []*JarCookie) []*http.Cookie {
+	var result []*http.Cookie
+	for _, c := range cookies {
+		result = append(result, &http.Cookie{
+			Name:  c.Name,
+			Value: c.Value,
+		})
+	}
+
+	return result
+}
+
+func withoutHttpOnly(cookies []*JarCookie) []*JarCookie {
+	var result []*JarCookie
+	for _, c := range cookies {
+		if !c.HttpOnly {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+// Matched cookies of the url, sorted as RFC 6265 section 5.4. site is the top
+// level request, nil if it's unknown.
+func (this *Jar) cookies(u *url.URL, site *cookieSite, now time.Time) []*JarCookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return nil
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	key := this.jarKey(host)
+	submap := this.entries[key]
+
+	var selected []*JarCookie
+	for id, c := range submap {
+		if c.expired(now) {
+			delete(submap, id)
+			continue
+		}
+
+		if !c.match(host, path, u.Scheme == "https") || !this.sendCookie(host, site, c) {
+			continue
+		}
+
+		c.LastAccess = now
+		cp := *c
+		selected = append(selected, &cp)
+	}
+
+	if len(submap) == 0 {
+		delete(this.entries, key)
+	}
+
+	// longer paths first, then earlier creation
+	sort.Slice(selected, func(i, j int) bool {
+		if len(selected[i].Path) != len(selected[j].Path) {
+			return len(selected[i].Path) > len(selected[j].Path)
+		}
+		if !selected[i].Creation.Equal(selected[j].Creation) {
+			return selected[i].Creation.Before(selected[j].Creation)
+		}
+		return selected[i].seqNum < selected[j].seqNum
+	})
+
+	return selected
+}
+
+func (this *Jar) setCookies(u *url.URL, site *cookieSite, cookies []*http.Cookie, now time.Time) {
+	if len(cookies) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return
+	}
+
+	defPath := defaultPath(u.Path)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, cookie := range cookies {
+		c, remove, ok := this.newEntry(cookie, now, defPath, host)
+		if !ok || !this.acceptCookie(u, host, site, cookie, c) {
+			continue
+		}
+
+		if remove {
+			this.remove(c)
+			continue
+		}
+
+		this.add(c, now)
+		this.evict(this.jarKey(c.Domain), now)
+	}
+}
+
+// Build the jar entry of a received cookie. remove is true when the cookie
+// deletes an existing one.
+func (this *Jar) newEntry(cookie *http.Cookie, now time.Time, defPath, host string) (c *JarCookie, remove, ok bool) {
+	c = &JarCookie{
+		Name:     cookie.Name,
+		Value:    cookie.Value,
+		Secure:   cookie.Secure,
+		HttpOnly: cookie.HttpOnly,
+		SameSite: cookie.SameSite,
+	}
+
+	if cookie.Path == "" || cookie.Path[0] != '/' {
+		c.Path = defPath
+	} else {
+		c.Path = cookie.Path
+	}
+
+	var err error
+	c.Domain, c.HostOnly, err = this.domainAndType(host, cookie.Domain)
+	if err != nil {
+		return nil, false, false
+	}
+
+	if cookie.MaxAge < 0 {
+		return c, true, true
+	} else if cookie.MaxAge > 0 {
+		c.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+	} else if !cookie.Expires.IsZero() {
+		if !cookie.Expires.After(now) {
+			return c, true, true
+		}
+		c.Expires = cookie.Expires
+	}
+
+	return c, false, true
+}
+
+// Domain of the cookie, and whether it's host only.
+func (this *Jar) domainAndType(host, domain string) (string, bool, error) {
+	if domain == "" {
+		return host, true, nil
+	}
+
+	if net.ParseIP(host) != nil {
+		// cookies of IP addresses must be host only
+		if host != domain {
+			return "", false, errIllegalDomain
+		}
+		return host, true, nil
+	}
+
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || strings.HasSuffix(domain, ".") {
+		return "", false, errIllegalDomain
+	}
+
+	// cookies of a public suffix are only allowed for the exact host
+	if this.psList != nil {
+		if suffix := this.psList.PublicSuffix(domain); suffix != "" && domain == suffix {
+			if host == domain {
+				return host, true, nil
+			}
+			return "", false, errIllegalDomain
+		}
+	}
+
+	if !domainMatch(host, domain) {
+		return "", false, errIllegalDomain
+	}
+
+	// top level domains
+	if host != domain && !strings.Contains(domain, ".") {
+		return "", false, errIllegalDomain
+	}
+
+	return domain, false, nil
+}
+
+// Add or replace a cookie, the creation time of an existing cookie is kept.
+// Must be called with the lock.
+func (this *Jar) add(c *JarCookie, now time.Time) {
+	key := this.jarKey(c.Domain)
+	submap := this.entries[key]
+	if submap == nil {
+		submap = make(map[string]*JarCookie)
+		this.entries[key] = submap
+	}
+
+	if old, ok := submap[c.id()]; ok {
+		c.Creation = old.Creation
+		c.seqNum = old.seqNum
+	} else {
+		if c.Creation.IsZero() {
+			c.Creation = now
+		}
+		c.seqNum = this.nextSeqNum
+		this.nextSeqNum++
+	}
+
+	if c.LastAccess.IsZero() {
+		c.LastAccess = now
+	}
+	submap[c.id()] = c
+}
+
+// Remove a cookie, must be called with the lock.
+func (this *Jar) remove(c *JarCookie) {
+	key := this.jarKey(c.Domain)
+	if submap, ok := this.entries[key]; ok {
+		delete(submap, c.id())
+		if len(submap) == 0 {
+			delete(this.entries, key)
+		}
+	}
+}
+
+// All unexpired cookies, sorted by domain, path and name. HttpOnly cookies
+// are hidden if the policy says so.
+func (this *Jar) All() []*JarCookie {
+	cookies := this.all(time.Now())
+
+	this.lock.Lock()
+	hideHttpOnly := this.policy != nil && this.policy.HideHttpOnly
+	this.lock.Unlock()
+
+	if hideHttpOnly {
+		cookies = withoutHttpOnly(cookies)
+	}
+
+	return cookies
+}
+
+// Delete a cookie, returns false if it's not found.
+func (this *Jar) Delete(domain, path, name string) bool {
+	c := &JarCookie{
+		Domain: strings.ToLower(strings.TrimPrefix(domain, ".")),
+		Path:   path,
+		Name:   name,
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if _, ok := this.entries[this.jarKey(c.Domain)][c.id()]; !ok {
+		return false
+	}
+
+	this.remove(c)
+
+	return true
+}
+
+// Delete cookies of the domain and its subdomains, returns the number of
+// deleted cookies.
+func (this *Jar) ClearDomain(domain string) int {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	n := 0
+	for key, submap := range this.entries {
+		for id, c := range submap {
+			if domainMatch(c.Domain, domain) {
+				delete(submap, id)
+				n++
+			}
+		}
+
+		if len(submap) == 0 {
+			delete(this.entries, key)
+		}
+	}
+
+	return n
+}
+
+// Delete all cookies.
+func (this *Jar) Clear() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.entries = make(map[string]map[string]*JarCookie)
+}
+
+// Copies of all unexpired cookies in the creation order, which can be
+// restored later with Restore.
+func (this *Jar) Snapshot() []*JarCookie {
+	cookies := this.all(time.Now())
+	sort.SliceStable(cookies, func(i, j int) bool {
+		if !cookies[i].Creation.Equal(cookies[j].Creation) {
+			return cookies[i].Creation.Before(cookies[j].Creation)
+		}
+		return cookies[i].seqNum < cookies[j].seqNum
+	})
+
+	return cookies
+}
+
+// Replace all cookies of the jar with a snapshot.
+func (this *Jar) Restore(cookies []*JarCookie) {
+	this.Clear()
+	this.addAll(cookies, time.Now())
+}
+
+// Key to group cookies, cookies which might be sent to the same host share
+// the same key.
+func (this *Jar) jarKey(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	// the registrable domain
+	if this.psList != nil {
+		if key := EffectiveTLDPlusOne(this.psList, host); key != "" {
+			return key
+		}
+		return host
+	}
+
+	// the last two labels
+	i := strings.LastIndex(host, ".")
+	if i <= 0 {
+		return host
+	}
+	if j := strings.LastIndex(host[:i], "."); j >= 0 {
+		return host[j+1:]
+	}
+
+	return host
+}
+
+var errIllegalDomain = errors.New("illegal cookie domain attribute")
+
+// Lower case host without port.
+func canonicalHost(host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+
+	if host == "" {
+		return "", errors.New("empty host")
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, ".")), nil
+}
+
+// Domain matching of RFC 6265 section 5.1.3.
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+
+	return net.ParseIP(host) == nil && strings.HasSuffix(host, "."+domain)
+}
+
+// Path matching of RFC 6265 section 5.1.4.
+func pathMatch(path, cookiePath string) bool {
+	if path == cookiePath {
+		return true
+	}
+
+	if strings.HasPrefix(path, cookiePath) {
+		return strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+	}
+
+	return false
+}
+
+// Default path of RFC 6265 section 5.1.4.
+func defaultPath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+
+	return path[:i]
+}
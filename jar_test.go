@@ -0,0 +1,345 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func jarCookieNames(jar http.CookieJar, rawurl string) string {
+	u, _ := url.Parse(rawurl)
+	var names []string
+	for _, c := range jar.Cookies(u) {
+		names = append(names, c.Name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestJarScope(t *testing.T) {
+	jar := NewJar()
+	u, _ := url.Parse("http://www.example.com/a/b")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "1", Domain: ".example.com", Path: "/"},
+		{Name: "secure", Value: "1", Secure: true, Path: "/"},
+		{Name: "path", Value: "1", Path: "/a/b"},
+		{Name: "tld", Value: "1", Domain: "com"},
+		{Name: "other", Value: "1", Domain: "other.com"},
+		{Name: "expired", Value: "1", MaxAge: -1},
+	})
+
+	cases := map[string]string{
+		"http://www.example.com/a/b":   "path,host,domain",
+		"https://www.example.com/a/b":  "path,host,domain,secure",
+		"http://www.example.com/":      "domain",
+		"http://sub.example.com/a/b/c": "domain",
+		"http://example.com/":          "domain",
+		"http://other.com/":            "",
+	}
+
+	for u, expected := range cases {
+		if names := jarCookieNames(jar, u); names != expected {
+			t.Errorf("wrong cookies of %s: %s", u, names)
+		}
+	}
+
+	// delete
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "path", Path: "/a/b", Expires: time.Unix(1, 0)},
+	})
+	if names := jarCookieNames(jar, "http://www.example.com/a/b"); names != "host,domain" {
+		t.Error("cookie is not deleted:", names)
+	}
+}
+
+func TestCookieFile(t *testing.T) {
+	jar := NewJar()
+	u, _ := url.Parse("https://www.example.com/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "a"},
+		{Name: "persistent", Value: "b", Domain: "example.com", MaxAge: 3600, HttpOnly: true, Secure: true},
+	})
+
+	for _, format := range []int{COOKIE_FORMAT_NETSCAPE, COOKIE_FORMAT_JSON} {
+		var buf bytes.Buffer
+		if err := jar.Save(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded := NewJar()
+		if err := loaded.Load(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+
+		if names := jarCookieNames(loaded, "https://www.example.com/"); names != "persistent,session" {
+			t.Error("wrong loaded cookies:", format, names)
+		}
+
+		if names := jarCookieNames(loaded, "https://sub.example.com/"); names != "persistent" {
+			t.Error("wrong loaded cookies:", format, names)
+		}
+
+		all := loaded.all(time.Now())
+		if len(all) != 2 || !all[0].HttpOnly || !all[0].Secure || all[0].Expires.IsZero() {
+			t.Error("attributes are not kept:", format, all)
+		}
+	}
+
+	// curl's cookie file
+	jar = NewJar()
+	err := jar.Load(strings.NewReader("# Netscape HTTP Cookie File\n\n"+
+		"#HttpOnly_.example.com\tTRUE\t/\tFALSE\t0\ta\t1\n"+
+		"www.example.com\tFALSE\t/path\tFALSE\t4102444800\tb\t2\n"+
+		"example.com\tFALSE\t/\tFALSE\t1\texpired\t3\n"+
+		"example.com\tFALSE\t/\tFALSE\t0\tempty\n"), COOKIE_FORMAT_NETSCAPE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := jarCookieNames(jar, "http://www.example.com/path"); names != "b,a" {
+		t.Error("wrong cookies:", names)
+	}
+
+	if names := jarCookieNames(jar, "http://example.com/"); names != "a,empty" {
+		t.Error("wrong cookies:", names)
+	}
+}
+
+func TestCookieFileOption(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "new", Value: "1", MaxAge: 3600})
+		c, _ := r.Cookie("old")
+		if c != nil {
+			io.WriteString(w, c.Value)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	dir, err := ioutil.TempDir("", "cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cookies.txt")
+	ioutil.WriteFile(path, []byte(u.Hostname()+"\tFALSE\t/\tFALSE\t0\told\tvalue\n"), 0600)
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_COOKIEFILE: path,
+		OPT_COOKIEJAR:  path,
+	})
+
+	res, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); body != "value" {
+		t.Error("cookie file is not loaded:", body)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	jar := NewJar()
+	if err := jar.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := jarCookieNames(jar, server.URL); names != "new,old" {
+		t.Error("cookies are not saved:", names)
+	}
+
+	// missing file is ignored
+	_, err = NewHttpClient().
+		WithOption(OPT_COOKIEFILE, filepath.Join(dir, "missing.txt")).
+		Get(server.URL)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJarManagement(t *testing.T) {
+	c := NewHttpClient()
+	jar := c.CookieJar()
+	if jar == nil {
+		t.Fatal("jar should be created")
+	}
+
+	u, _ := url.Parse("http://www.example.com/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "a", Value: "1"},
+		{Name: "b", Value: "2", Domain: "example.com"},
+	})
+	u, _ = url.Parse("http://other.com/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "c", Value: "3"},
+	})
+
+	if c.CookieValue("http://www.example.com/", "b") != "2" {
+		t.Error("jar is not used by the client")
+	}
+
+	all := jar.All()
+	if len(all) != 3 || all[0].Domain != "example.com" || all[1].Domain != "other.com" || all[2].Domain != "www.example.com" {
+		t.Error("wrong cookies:", all)
+	}
+
+	snapshot := jar.Snapshot()
+
+	if jar.Delete("www.example.com", "/", "missing") {
+		t.Error("missing cookie should not be deleted")
+	}
+
+	if !jar.Delete("www.example.com", "/", "a") {
+		t.Error("cookie is not deleted")
+	}
+
+	if n := jar.ClearDomain(".example.com"); n != 1 {
+		t.Error("wrong number of deleted cookies:", n)
+	}
+
+	if names := jarCookieNames(jar, "http://www.example.com/"); names != "" {
+		t.Error("cookies are not deleted:", names)
+	}
+
+	jar.Restore(snapshot)
+	if names := jarCookieNames(jar, "http://www.example.com/"); names != "a,b" {
+		t.Error("cookies are not restored:", names)
+	}
+
+	jar.Clear()
+	if len(jar.All()) != 0 {
+		t.Error("jar is not cleared")
+	}
+
+	if NewHttpClient().Defaults(Map{OPT_COOKIEJAR: false}).CookieJar() != nil {
+		t.Error("disabled jar should be nil")
+	}
+}
+
+func TestJarConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "1"})
+	}))
+	defer server.Close()
+
+	c := NewHttpClient()
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			res, err := c.Begin().Get(server.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			done <- err
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		c.CookieJar()
+		c.Cookies(server.URL)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if names := jarCookieNames(c.CookieJar(), server.URL); names != "a" {
+		t.Error("wrong cookies:", names)
+	}
+}
+
+// A zero value client works without NewHttpClient.
+func TestJarZeroClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "1"})
+	}))
+	defer server.Close()
+
+	c := &HttpClient{}
+	res, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 200 {
+		t.Error("wrong status:", res.StatusCode)
+	}
+	c.Cookies(server.URL)
+}
+
+func TestRequestCookie(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/echo", 302)
+			return
+		}
+
+		var names []string
+		for _, c := range r.Cookies() {
+			names = append(names, c.Name+"="+c.Value)
+		}
+		io.WriteString(w, strings.Join(names, ","))
+	}))
+	defer server.Close()
+
+	c := NewHttpClient()
+	get := func(c *HttpClient, path string) string {
+		res, err := c.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := res.ToString()
+		return body
+	}
+
+	body := get(c.WithRequestCookie(&http.Cookie{Name: "a", Value: "1"}), "/redirect")
+	if body != "a=1" {
+		t.Error("request cookie is not sent with redirects:", body)
+	}
+
+	if body := get(c, "/echo"); body != "" {
+		t.Error("request cookie should not be kept:", body)
+	}
+
+	if len(c.CookieJar().All()) != 0 {
+		t.Error("request cookie should not be added to the jar")
+	}
+
+	body = get(c.WithOption(OPT_COOKIE, "b=2; c=3"), "/echo")
+	if body != "b=2,c=3" {
+		t.Error("OPT_COOKIE is not sent:", body)
+	}
+
+	// without jar
+	c = NewHttpClient().Defaults(Map{
+		OPT_COOKIEJAR: false,
+		OPT_COOKIE:    "d=4",
+	})
+	if body := get(c, "/echo"); body != "d=4" {
+		t.Error("OPT_COOKIE is not sent:", body)
+	}
+
+	if _, err := c.WithOption(OPT_COOKIE, 1).Get(server.URL); err == nil {
+		t.Error("invalid OPT_COOKIE should fail")
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default bytes of bodies to log, see OPT_LOG_BODY_LIMIT.
+const defaultLogBodyLimit = 4096
+
+// Replacement of redacted values.
+const redacted = "[REDACTED]"
+
+// A field of JSON text, the value might be truncated.
+var jsonFieldPattern = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"\s*:\s*("(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`)
+
+// Names always redacted in headers, query params and JSON fields, see
+// OPT_LOG_REDACT.
+var defaultLogRedact = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Amz-Security-Token",
+	"X-Signature",
+	"password",
+	"access_token",
+	"refresh_token",
+	"client_secret",
+}
+
+// A request sent, see OPT_LOGGER.
+type RequestLog struct {
+	Method string
+	URL    string
+	Header http.Header
+
+	// Up to OPT_LOG_BODY_LIMIT bytes, nil if the body can not be read
+	// before it's sent(e.g. a streaming io.Reader).
+	Body          []byte
+	BodyTruncated bool
+}
+
+// A response received(or the error of a request), see OPT_LOGGER.
+type ResponseLog struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+
+	// Up to OPT_LOG_BODY_LIMIT bytes, compressed bodies are not logged.
+	Body          []byte
+	BodyTruncated bool
+
+	// From the request is sent to the body is read.
+	Duration time.Duration
+
+	// Error of the request or reading the body.
+	Err error
+}
+
+// A logger receives requests and responses, with secrets redacted. It must be
+// safe for concurrent use. See OPT_LOGGER.
+type Logger interface {
+	// Called before a request is sent(including redirects and retries).
+	LogRequest(req *RequestLog)
+
+	// Called when the body of the response is read or closed, or right
+	// after the response is received if bodies are not logged.
+	LogResponse(res *ResponseLog)
+}
+
+// A logger writes requests and responses as text(like curl -v).
+type textLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// Create a logger writes requests and responses as text(like curl -v), it's
+// used by OPT_DEBUG(with stdout).
+func NewTextLogger(w io.Writer) Logger {
+	return &textLogger{
+		w: w,
+	}
+}
+
+func (this *textLogger) LogRequest(req *RequestLog) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "> %s %s\n", req.Method, req.URL)
+	writeLogHeader(buf, "> ", req.Header)
+	writeLogBody(buf, req.Body, req.BodyTruncated)
+
+	this.write(buf.Bytes())
+}
+
+func (this *textLogger) LogResponse(res *ResponseLog) {
+	buf := &bytes.Buffer{}
+	if res.StatusCode == 0 {
+		fmt.Fprintf(buf, "< %s %s: %v (%v)\n", res.Method, res.URL, res.Err, res.Duration)
+	} else {
+		fmt.Fprintf(buf, "< %d %s (%v)\n", res.StatusCode, http.StatusText(res.StatusCode), res.Duration)
+		writeLogHeader(buf, "< ", res.Header)
+		writeLogBody(buf, res.Body, res.BodyTruncated)
+		if res.Err != nil {
+			fmt.Fprintf(buf, "< error: %v\n", res.Err)
+		}
+	}
+
+	this.write(buf.Bytes())
+}
+
+func (this *textLogger) write(p []byte) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.w.Write(p)
+}
+
+func writeLogHeader(w io.Writer, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+func writeLogBody(w io.Writer, body []byte, truncated bool) {
+	if len(body) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s\n", body)
+	if truncated {
+		fmt.Fprintf(w, "[truncated]\n")
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// Redact secrets of logs.
+type redactor struct {
+	// Lower case names.
+	names map[string]bool
+}
+
+func newRedactor(names []string) *redactor {
+	this := &redactor{
+		names: make(map[string]bool),
+	}
+
+	for _, name := range defaultLogRedact {
+		this.names[strings.ToLower(name)] = true
+	}
+	for _, name := range names {
+		this.names[strings.ToLower(name)] = true
+	}
+
+	return this
+}
+
+func (this *redactor) match(name string) bool {
+	return this.names[strings.ToLower(name)]
+}
+
+func (this *redactor) header(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for name, values := range header {
+		if this.match(name) {
+			result[name] = []string{redacted}
+		} else {
+			result[name] = append([]string(nil), values...)
+		}
+	}
+
+	return result
+}
+
+func (this *redactor) values(values url.Values) url.Values {
+	result := make(url.Values, len(values))
+	for name, v := range values {
+		if this.match(name) {
+			result[name] = []string{redacted}
+		} else {
+			result[name] = v
+		}
+	}
+
+	return result
+}
+
+func (this *redactor) url(u *url.URL) string {
+	redactedURL := *u
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			redactedURL.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+
+	if u.RawQuery != "" {
+		redactedURL.RawQuery = this.values(u.Query()).Encode()
+	}
+
+	return redactedURL.String()
+}
+
+// Redact JSON fields and form values of a body, the body is kept as is if
+// there is nothing to redact. Truncated JSON is redacted as text.
+func (this *redactor) body(body []byte, truncated bool, contentType string) []byte {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "json"):
+		var v interface{}
+		if truncated || json.Unmarshal(body, &v) != nil {
+			return jsonFieldPattern.ReplaceAllFunc(body, func(field []byte) []byte {
+				m := jsonFieldPattern.FindSubmatch(field)
+				if !this.match(string(m[1])) {
+					return field
+				}
+
+				// field is a part of the body, do not append to it
+				result := make([]byte, 0, len(field)+len(redacted))
+				result = append(result, field[:len(field)-len(m[2])]...)
+
+				return append(result, `"`+redacted+`"`...)
+			})
+		}
+
+		if !this.json(v) {
+			return body
+		}
+
+		if data, err := json.Marshal(v); err == nil {
+			return data
+		}
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		pairs := strings.Split(string(body), "&")
+		for i, pair := range pairs {
+			name := strings.SplitN(pair, "=", 2)[0]
+			unescaped, err := url.QueryUnescape(name)
+			if err != nil {
+				unescaped = name
+			}
+
+			if this.match(unescaped) {
+				pairs[i] = name + "=" + url.QueryEscape(redacted)
+			}
+		}
+
+		return []byte(strings.Join(pairs, "&"))
+	}
+
+	return body
+}
+
+// Redact fields of a JSON value in place, returns whether any field is
+// redacted.
+func (this *redactor) json(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if this.match(key) {
+				v[key] = redacted
+				changed = true
+			} else if this.json(value) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if this.json(value) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// Logging options of a request.
+type logConfig struct {
+	logger    Logger
+	bodyLimit int
+	redactor  *redactor
+}
+
+// A body of the log, up to the limit bytes.
+func (this *logConfig) body(data []byte, truncated bool, contentType string) ([]byte, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	return this.redactor.body(data, truncated, contentType), truncated
+}
+
+// A RoundTripper logs requests and responses.
+type logTransport struct {
+	transport http.RoundTripper
+	config    *logConfig
+}
+
+// Implement http.RoundTripper.
+func (this *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	config := this.config
+	requestLog := &RequestLog{
+		Method: req.Method,
+		URL:    config.redactor.url(req.URL),
+		Header: config.redactor.header(req.Header),
+	}
+
+	// the body is read from a copy
+	if config.bodyLimit > 0 && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := ioutil.ReadAll(io.LimitReader(body, int64(config.bodyLimit)+1))
+			body.Close()
+
+			truncated := len(data) > config.bodyLimit
+			if truncated {
+				data = data[:config.bodyLimit]
+			}
+			requestLog.Body, requestLog.BodyTruncated = config.body(data, truncated, req.Header.Get("Content-Type"))
+		}
+	}
+
+	config.logger.LogRequest(requestLog)
+
+	start := time.Now()
+	res, err := this.transport.RoundTrip(req)
+
+	responseLog := &ResponseLog{
+		Method: requestLog.Method,
+		URL:    requestLog.URL,
+	}
+
+	if err != nil {
+		responseLog.Duration = time.Since(start)
+		responseLog.Err = err
+		config.logger.LogResponse(responseLog)
+		return nil, err
+	}
+
+	responseLog.StatusCode = res.StatusCode
+	responseLog.Header = config.redactor.header(res.Header)
+
+	// compressed bodies are not logged
+	encoding := res.Header.Get("Content-Encoding")
+	if config.bodyLimit <= 0 || res.Body == nil || res.Body == http.NoBody ||
+		(encoding != "" && encoding != "identity") {
+		responseLog.Duration = time.Since(start)
+		config.logger.LogResponse(responseLog)
+		return res, nil
+	}
+
+	res.Body = &logBody{
+		ReadCloser:  res.Body,
+		config:      config,
+		log:         responseLog,
+		start:       start,
+		contentType: res.Header.Get("Content-Type"),
+	}
+
+	return res, nil
+}
+
+// A response body logs the response when it's read or closed.
+type logBody struct {
+	io.ReadCloser
+	config      *logConfig
+	log         *ResponseLog
+	start       time.Time
+	contentType string
+
+	buf       bytes.Buffer
+	truncated bool
+	once      sync.Once
+}
+
+func (this *logBody) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+
+	if remaining := this.config.bodyLimit - this.buf.Len(); remaining < n {
+		if remaining > 0 {
+			this.buf.Write(p[:remaining])
+		}
+		this.truncated = true
+	} else {
+		this.buf.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		this.done(nil)
+	} else if err != nil {
+		this.done(err)
+	}
+
+	return n, err
+}
+
+func (this *logBody) Close() error {
+	this.done(nil)
+
+	return this.ReadCloser.Close()
+}
+
+func (this *logBody) done(err error) {
+	this.once.Do(func() {
+		this.log.Duration = time.Since(this.start)
+		this.log.Body, this.log.BodyTruncated = this.config.body(this.buf.Bytes(), this.truncated, this.contentType)
+		this.log.Err = err
+		this.config.logger.LogResponse(this.log)
+	})
+}
+
+// Prepare logging with OPT_LOGGER(or OPT_DEBUG), OPT_LOG_BODY_LIMIT and
+// OPT_LOG_REDACT.
+func prepareLogger(options map[int]interface{}) (*logConfig, error) {
+	var logger Logger
+	if logger_, ok := options[OPT_LOGGER]; ok && logger_ != nil {
+		if logger, ok = logger_.(Logger); !ok {
+			return nil, fmt.Errorf("OPT_LOGGER must be httpclient.Logger")
+		}
+	}
+
+	if debug_, ok := options[OPT_DEBUG]; ok {
+		debug, ok := debug_.(bool)
+		if !ok {
+			return nil, fmt.Errorf("OPT_DEBUG must be bool")
+		}
+
+		if debug && logger == nil {
+			logger = NewTextLogger(os.Stdout)
+		}
+	}
+
+	if logger == nil {
+		return nil, nil
+	}
+
+	bodyLimit := defaultLogBodyLimit
+	if bodyLimit_, ok := options[OPT_LOG_BODY_LIMIT]; ok {
+		if bodyLimit, ok = bodyLimit_.(int); !ok {
+			return nil, fmt.Errorf("OPT_LOG_BODY_LIMIT must be int")
+		}
+	}
+
+	var names []string
+	if names_, ok := options[OPT_LOG_REDACT]; ok {
+		if names, ok = names_.([]string); !ok {
+			return nil, fmt.Errorf("OPT_LOG_REDACT must be []string")
+		}
+	}
+
+	return &logConfig{
+		logger:    logger,
+		bodyLimit: bodyLimit,
+		redactor:  newRedactor(names),
+	}, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testLogger struct {
+	lock      sync.Mutex
+	requests  []*RequestLog
+	responses []*ResponseLog
+}
+
+func (this *testLogger) LogRequest(req *RequestLog) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.requests = append(this.requests, req)
+}
+
+func (this *testLogger) LogResponse(res *ResponseLog) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.responses = append(this.responses, res)
+}
+
+func TestLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte(`{"access_token":"secret","user":{"name":"a","secret":"b"}}`))
+	}))
+	defer server.Close()
+
+	logger := &testLogger{}
+	c := NewHttpClient().Defaults(Map{
+		OPT_LOGGER:     logger,
+		OPT_LOG_REDACT: []string{"X-Api-Key", "secret"},
+		OPT_USERPWD:    "user:pass",
+	})
+
+	res, err := c.
+		WithHeader("X-Api-Key", "key").
+		WithHeader("X-Amz-Security-Token", "token").
+		WithHeader("X-Signature", "signature").
+		PostJson(server.URL+"/path?access_token=secret&page=1", map[string]interface{}{
+			"password": "secret",
+			"name":     "a",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logger.responses) != 0 {
+		t.Error("response should be logged after the body is read")
+	}
+
+	if body, _ := res.ToString(); !strings.Contains(body, `"access_token":"secret"`) {
+		t.Error("the body should not be changed:", body)
+	}
+
+	if len(logger.requests) != 1 || len(logger.responses) != 1 {
+		t.Fatal("wrong number of logs:", len(logger.requests), len(logger.responses))
+	}
+
+	req := logger.requests[0]
+	if req.Method != "POST" || req.URL != server.URL+"/path?access_token=%5BREDACTED%5D&page=1" {
+		t.Error("wrong request:", req.Method, req.URL)
+	}
+	if req.Header.Get("Authorization") != "[REDACTED]" || req.Header.Get("X-Api-Key") != "[REDACTED]" ||
+		req.Header.Get("X-Amz-Security-Token") != "[REDACTED]" || req.Header.Get("X-Signature") != "[REDACTED]" {
+		t.Error("headers should be redacted:", req.Header)
+	}
+	if string(req.Body) != `{"name":"a","password":"[REDACTED]"}` {
+		t.Error("wrong request body:", string(req.Body))
+	}
+
+	res_ := logger.responses[0]
+	if res_.StatusCode != 200 || res_.Header.Get("Set-Cookie") != "[REDACTED]" || res_.Duration <= 0 {
+		t.Error("wrong response:", res_)
+	}
+	if string(res_.Body) != `{"access_token":"[REDACTED]","user":{"name":"a","secret":"[REDACTED]"}}` {
+		t.Error("wrong response body:", string(res_.Body))
+	}
+}
+
+func TestLoggerBodyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"password":"secret","data":"` + strings.Repeat("a", 100) + `"}`))
+	}))
+	defer server.Close()
+
+	logger := &testLogger{}
+	c := NewHttpClient().Defaults(Map{
+		OPT_LOGGER:         logger,
+		OPT_LOG_BODY_LIMIT: 30,
+	})
+
+	res, err := c.Post(server.URL, map[string]string{
+		"password": "secret",
+		"data":     strings.Repeat("a", 100),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	req := logger.requests[0]
+	if !req.BodyTruncated || string(req.Body) != "data=aaaaaaaaaaaaaaaaaaaaaaaaa" {
+		t.Error("wrong request body:", string(req.Body))
+	}
+
+	// truncated JSON is redacted
+	res, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	if res_ := logger.responses[1]; !res_.BodyTruncated || string(res_.Body) != `{"password":"[REDACTED]","data":"a` {
+		t.Error("wrong response body:", string(res_.Body))
+	}
+
+	// bodies are not logged
+	res, err = c.WithOption(OPT_LOG_BODY_LIMIT, 0).Post(server.URL, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.responses) != 3 || logger.requests[2].Body != nil || logger.responses[2].Body != nil {
+		t.Error("bodies should not be logged")
+	}
+	res.Body.Close()
+
+	// errors
+	server.Close()
+	if _, err := c.Get(server.URL); err == nil {
+		t.Fatal("request should fail")
+	}
+	if res_ := logger.responses[len(logger.responses)-1]; res_.Err == nil || res_.StatusCode != 0 {
+		t.Error("error should be logged:", res_)
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewTextLogger(buf)
+	logger.LogRequest(&RequestLog{
+		Method: "POST",
+		URL:    "http://example.com/",
+		Header: http.Header{"Content-Type": {"text/plain"}},
+		Body:   []byte("hello"),
+	})
+	logger.LogResponse(&ResponseLog{
+		Method:     "POST",
+		URL:        "http://example.com/",
+		StatusCode: 200,
+		Header:     http.Header{"Content-Length": {"2"}},
+		Body:       []byte("ok"),
+	})
+
+	expected := "> POST http://example.com/\n> Content-Type: text/plain\n\nhello\n\n" +
+		"< 200 OK (0s)\n< Content-Length: 2\n\nok\n\n"
+	if buf.String() != expected {
+		t.Errorf("wrong output: %q", buf.String())
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Values of OPT_NETRC, similar to CURL_NETRC_*.
+const (
+	// .netrc is not used.
+	NETRC_IGNORED = iota
+
+	// Credentials of the url and options take precedence over .netrc.
+	NETRC_OPTIONAL
+
+	// Credentials of .netrc take precedence over the url(but not
+	// OPT_USERPWD).
+	NETRC_REQUIRED
+)
+
+// A machine(or default) entry of .netrc.
+type netrcEntry struct {
+	// Empty for the default entry.
+	machine  string
+	login    string
+	password string
+}
+
+// Split .netrc into tokens, double quoted tokens may contain spaces.
+func netrcTokens(data string) []string {
+	var tokens []string
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		s := line
+		for {
+			s = strings.TrimLeft(s, " \t\r")
+			if s == "" {
+				break
+			}
+
+			if s[0] == '"' {
+				token, rest := readTokenOrQuoted(s)
+				tokens = append(tokens, token)
+				s = rest
+				continue
+			}
+
+			i := strings.IndexAny(s, " \t\r")
+			if i < 0 {
+				i = len(s)
+			}
+			tokens = append(tokens, s[:i])
+			s = s[i:]
+		}
+
+		// an empty line ends a macro definition
+		if strings.TrimSpace(line) == "" {
+			tokens = append(tokens, "")
+		}
+	}
+
+	return tokens
+}
+
+// Parse .netrc content.
+func parseNetrc(data string) ([]netrcEntry, error) {
+	var entries []netrcEntry
+	var entry *netrcEntry
+	tokens := netrcTokens(data)
+
+	next := func(i int, keyword string) (string, error) {
+		if i+1 >= len(tokens) || tokens[i+1] == "" {
+			return "", fmt.Errorf("netrc: missing value of %s", keyword)
+		}
+
+		return tokens[i+1], nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "":
+		case "machine":
+			machine, err := next(i, "machine")
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, netrcEntry{machine: machine})
+			entry = &entries[len(entries)-1]
+			i++
+		case "default":
+			entries = append(entries, netrcEntry{})
+			entry = &entries[len(entries)-1]
+		case "login", "password", "account":
+			value, err := next(i, tokens[i])
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				return nil, fmt.Errorf("netrc: %s outside of a machine", tokens[i])
+			}
+			if tokens[i] == "login" {
+				entry.login = value
+			} else if tokens[i] == "password" {
+				entry.password = value
+			}
+			i++
+		case "macdef":
+			// skip until the empty line
+			for i+1 < len(tokens) && tokens[i+1] != "" {
+				i++
+			}
+		default:
+			return nil, fmt.Errorf("netrc: unknown token %q", tokens[i])
+		}
+	}
+
+	return entries, nil
+}
+
+// Default path of .netrc, $NETRC is respected.
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+
+	return filepath.Join(home, ".netrc")
+}
+
+// Find credentials of the host in .netrc, the login must also match if it's
+// not empty. A missing file is not an error.
+func lookupNetrc(path string, host string, login string) (*netrcEntry, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseNetrc(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.machine != "" && !strings.EqualFold(entry.machine, host) {
+			continue
+		}
+
+		if login != "" && entry.login != login {
+			continue
+		}
+
+		return &entry, nil
+	}
+
+	return nil, nil
+}
+
+// Prepare the .netrc mode and file path with OPT_NETRC and OPT_NETRC_FILE.
+func prepareNetrc(options map[int]interface{}) (int, string, error) {
+	mode := NETRC_IGNORED
+	path := ""
+
+	if file_, ok := options[OPT_NETRC_FILE]; ok {
+		if path, ok = file_.(string); !ok {
+			return 0, "", fmt.Errorf("OPT_NETRC_FILE must be string")
+		}
+
+		// the file implies .netrc
+		mode = NETRC_OPTIONAL
+	}
+
+	if netrc_, ok := options[OPT_NETRC]; ok {
+		switch netrc := netrc_.(type) {
+		case int:
+			mode = netrc
+		case bool:
+			if netrc {
+				mode = NETRC_OPTIONAL
+			} else {
+				mode = NETRC_IGNORED
+			}
+		default:
+			return 0, "", fmt.Errorf("OPT_NETRC must be int or bool")
+		}
+	}
+
+	if mode != NETRC_IGNORED && path == "" {
+		path = defaultNetrcPath()
+	}
+
+	return mode, path, nil
+}
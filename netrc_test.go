@@ -0,0 +1,118 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	entries, err := parseNetrc(`
+# comment
+machine example.com login user password "pass word"
+macdef init
+cd /pub
+bin
+
+machine other.com
+    login other
+    account acc
+    password secret
+default login anonymous password guest
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatal("wrong entries:", entries)
+	}
+
+	if entries[0].machine != "example.com" || entries[0].login != "user" || entries[0].password != "pass word" {
+		t.Error("wrong entry:", entries[0])
+	}
+
+	if entries[1].machine != "other.com" || entries[1].login != "other" || entries[1].password != "secret" {
+		t.Error("wrong entry:", entries[1])
+	}
+
+	if entries[2].machine != "" || entries[2].login != "anonymous" {
+		t.Error("wrong default entry:", entries[2])
+	}
+
+	if _, err := parseNetrc("machine"); err == nil {
+		t.Error("incomplete netrc should fail")
+	}
+}
+
+func TestNetrc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		io.WriteString(w, username+":"+password)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	dir, err := ioutil.TempDir("", "netrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "netrc")
+	ioutil.WriteFile(path, []byte("machine "+u.Hostname()+" login user password pass\n"+
+		"machine "+u.Hostname()+" login other password secret\n"), 0600)
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_NETRC_FILE: path,
+	})
+
+	cases := []struct {
+		url      string
+		options  Map
+		expected string
+	}{
+		{server.URL, nil, "user:pass"},
+		// url takes precedence
+		{strings.Replace(server.URL, "://", "://foo:bar@", 1), nil, "foo:bar"},
+		// password of the login
+		{strings.Replace(server.URL, "://", "://other@", 1), nil, "other:secret"},
+		// options take precedence
+		{server.URL, Map{OPT_USERPWD: "a:b"}, "a:b"},
+		// netrc takes precedence
+		{strings.Replace(server.URL, "://", "://foo:bar@", 1), Map{OPT_NETRC: NETRC_REQUIRED}, "user:pass"},
+		{server.URL, Map{OPT_NETRC: NETRC_IGNORED}, ":"},
+	}
+
+	for _, v := range cases {
+		res, err := c.WithOptions(v.options).Get(v.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body, _ := res.ToString(); body != v.expected {
+			t.Error("wrong credentials:", v.url, v.options, body)
+		}
+	}
+
+	// missing file is ignored
+	res, err := NewHttpClient().
+		WithOption(OPT_NETRC_FILE, filepath.Join(dir, "missing")).
+		Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); body != ":" {
+		t.Error("unexpected credentials:", body)
+	}
+}
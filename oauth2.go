@@ -0,0 +1,377 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2 grant types.
+const (
+	GRANT_CLIENT_CREDENTIALS = "client_credentials"
+	GRANT_PASSWORD           = "password"
+	GRANT_REFRESH_TOKEN      = "refresh_token"
+)
+
+// Tokens are considered expired a little earlier, so that they will not
+// expire on the way.
+const tokenExpiryDelta = 10 * time.Second
+
+// Timeout of token requests.
+const tokenRequestTimeout = 30 * time.Second
+
+// An OAuth2 token.
+type Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+
+	// Zero means the token never expires.
+	Expiry time.Time
+}
+
+// Is the token still usable?
+func (this *Token) Valid() bool {
+	if this == nil || this.AccessToken == "" {
+		return false
+	}
+
+	return this.Expiry.IsZero() || time.Now().Add(tokenExpiryDelta).Before(this.Expiry)
+}
+
+// Value of the Authorization header.
+func (this *Token) authorization() string {
+	tokenType := this.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+
+	return tokenType + " " + this.AccessToken
+}
+
+// TokenSource supplies tokens of OPT_OAUTH2, it must be safe for concurrent
+// use.
+type TokenSource interface {
+	// Get a valid token, the transport should be used if a token needs to be
+	// fetched. It should return when the context(of the request) is done.
+	Token(ctx context.Context, transport http.RoundTripper) (*Token, error)
+
+	// The token has been rejected by the server, so that the next call to
+	// Token will not return it again.
+	Invalidate(token *Token)
+}
+
+// Configuration of an OAuth2 token endpoint.
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// One of GRANT_CLIENT_CREDENTIALS(default), GRANT_PASSWORD and
+	// GRANT_REFRESH_TOKEN.
+	GrantType string
+
+	// For GRANT_PASSWORD.
+	Username string
+	Password string
+
+	// For GRANT_REFRESH_TOKEN.
+	RefreshToken string
+
+	// Send client credentials in the request body instead of basic auth.
+	ClientAuthInBody bool
+
+	// Extra parameters of token requests.
+	Params map[string]string
+}
+
+// TokenSource that fetches tokens from an OAuth2 token endpoint, and refreshes
+// them when they expire or get rejected.
+type OAuth2TokenSource struct {
+	config OAuth2Config
+
+	token *Token
+
+	// Latest refresh token, it might be rotated by the server.
+	refreshToken string
+
+	lock sync.Mutex
+
+	// Serialize token requests, waiting for it can be canceled.
+	fetching chan struct{}
+}
+
+// Create an OAuth2 token source.
+func NewOAuth2TokenSource(config OAuth2Config) *OAuth2TokenSource {
+	if config.GrantType == "" {
+		config.GrantType = GRANT_CLIENT_CREDENTIALS
+	}
+
+	return &OAuth2TokenSource{
+		config:       config,
+		refreshToken: config.RefreshToken,
+		fetching:     make(chan struct{}, 1),
+	}
+}
+
+// Get a valid token, fetch a new one if necessary. Other requests wait for the
+// token being fetched until their contexts are done.
+func (this *OAuth2TokenSource) Token(ctx context.Context, transport http.RoundTripper) (*Token, error) {
+	this.lock.Lock()
+	if this.token.Valid() {
+		token := this.token
+		this.lock.Unlock()
+		return token, nil
+	}
+	if this.fetching == nil {
+		this.fetching = make(chan struct{}, 1)
+	}
+	fetching := this.fetching
+	this.lock.Unlock()
+
+	select {
+	case fetching <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-fetching
+	}()
+
+	// fetched by another request while waiting
+	this.lock.Lock()
+	current, refreshToken := this.token, this.refreshToken
+	this.lock.Unlock()
+	if current.Valid() {
+		return current, nil
+	}
+
+	var token *Token
+	var err error
+	if refreshToken != "" {
+		token, err = this.fetch(ctx, transport, map[string]string{
+			"grant_type":    GRANT_REFRESH_TOKEN,
+			"refresh_token": refreshToken,
+		})
+
+		// fallback to the original grant
+		if err != nil && this.config.GrantType != GRANT_REFRESH_TOKEN {
+			token, err = nil, nil
+		}
+	}
+
+	if token == nil && err == nil {
+		params := map[string]string{
+			"grant_type": this.config.GrantType,
+		}
+
+		switch this.config.GrantType {
+		case GRANT_PASSWORD:
+			params["username"] = this.config.Username
+			params["password"] = this.config.Password
+		case GRANT_REFRESH_TOKEN:
+			return nil, &Error{
+				Code:    ERR_OAUTH2,
+				Message: "refresh token is missing",
+			}
+		}
+
+		token, err = this.fetch(ctx, transport, params)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.token = token
+	if token.RefreshToken != "" {
+		this.refreshToken = token.RefreshToken
+	}
+
+	return token, nil
+}
+
+// Drop the token if it's still the current one.
+func (this *OAuth2TokenSource) Invalidate(token *Token) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.token == token {
+		this.token = nil
+	}
+}
+
+// A context is done with its parent, without values of the parent(e.g.
+// traces of the request).
+type cancelContext struct {
+	context.Context
+}
+
+func (this cancelContext) Value(key interface{}) interface{} {
+	return nil
+}
+
+// Request a token from the token endpoint, within tokenRequestTimeout.
+func (this *OAuth2TokenSource) fetch(ctx context.Context, transport http.RoundTripper, params map[string]string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(cancelContext{ctx}, tokenRequestTimeout)
+	defer cancel()
+
+	if len(this.config.Scopes) > 0 {
+		params["scope"] = strings.Join(this.config.Scopes, " ")
+	}
+
+	for k, v := range this.config.Params {
+		params[k] = v
+	}
+
+	c := NewHttpClient()
+	c.transport = transport
+	c.WithHeader("Accept", "application/json")
+	c.WithOption(OPT_CONTEXT, ctx)
+	if this.config.ClientAuthInBody {
+		params["client_id"] = this.config.ClientID
+		if this.config.ClientSecret != "" {
+			params["client_secret"] = this.config.ClientSecret
+		}
+	} else if this.config.ClientID != "" {
+		c.WithOption(OPT_USERPWD, url.QueryEscape(this.config.ClientID)+":"+
+			url.QueryEscape(this.config.ClientSecret))
+	}
+
+	res, err := c.Post(this.config.TokenURL, params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &Error{
+			Code:    ERR_OAUTH2,
+			Message: fmt.Sprintf("cannot fetch token: %s %s", res.Status, body),
+		}
+	}
+
+	var data struct {
+		AccessToken  string      `json:"access_token"`
+		TokenType    string      `json:"token_type"`
+		RefreshToken string      `json:"refresh_token"`
+		ExpiresIn    json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, &Error{
+			Code:    ERR_OAUTH2,
+			Message: fmt.Sprintf("cannot parse token response: %v", err),
+		}
+	}
+
+	if data.AccessToken == "" {
+		return nil, &Error{
+			Code:    ERR_OAUTH2,
+			Message: "access token is missing in token response",
+		}
+	}
+
+	token := &Token{
+		AccessToken:  data.AccessToken,
+		TokenType:    data.TokenType,
+		RefreshToken: data.RefreshToken,
+	}
+
+	if expiresIn, err := data.ExpiresIn.Int64(); err == nil && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+
+	return token, nil
+}
+
+// Prepare the token source of a request.
+func prepareTokenSource(options map[int]interface{}) (TokenSource, error) {
+	if source_, ok := options[OPT_OAUTH2]; ok && source_ != nil {
+		if source, ok := source_.(TokenSource); ok {
+			return source, nil
+		}
+
+		return nil, fmt.Errorf("OPT_OAUTH2 must be a TokenSource")
+	}
+
+	return nil, nil
+}
+
+// Transport which attaches OAuth2 tokens to requests, the request is retried
+// once with a new token if the server rejects the token.
+type oauth2Transport struct {
+	transport http.RoundTripper
+
+	// Used to fetch tokens.
+	base http.RoundTripper
+
+	source TokenSource
+
+	// Tokens are only sent to this host, unless unrestricted is
+	// set(OPT_UNRESTRICTED_AUTH).
+	host         string
+	unrestricted bool
+}
+
+func (this *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != this.host && !this.unrestricted {
+		return this.transport.RoundTrip(req)
+	}
+
+	token, err := this.source.Token(req.Context(), this.base)
+	if err != nil {
+		return nil, err
+	}
+
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", token.authorization())
+	getRequestState(authorized).credentials = true
+	res, err := this.transport.RoundTrip(authorized)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// The body can not be sent again.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return res, nil
+	}
+
+	this.source.Invalidate(token)
+	token, err = this.source.Token(req.Context(), this.base)
+	if err != nil {
+		return res, nil
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", token.authorization())
+
+	return this.transport.RoundTrip(retry)
+}
@@ -0,0 +1,220 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A token endpoint and an API server accepting only the latest token.
+type oauth2TestServer struct {
+	*httptest.Server
+
+	// number of tokens issued
+	issued int32
+
+	expiresIn int
+
+	lock   sync.Mutex
+	token  string
+	grants []string
+}
+
+func newOAuth2TestServer(expiresIn int) *oauth2TestServer {
+	s := &oauth2TestServer{
+		expiresIn: expiresIn,
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		switch r.URL.Path {
+		case "/token":
+			id, secret, _ := r.BasicAuth()
+			if id != "id" || secret != "secret" {
+				w.WriteHeader(401)
+				return
+			}
+
+			grant := r.PostFormValue("grant_type")
+			if grant == GRANT_PASSWORD && r.PostFormValue("password") != "pass" ||
+				grant == GRANT_REFRESH_TOKEN && r.PostFormValue("refresh_token") != "refresh" {
+				w.WriteHeader(400)
+				return
+			}
+			s.grants = append(s.grants, grant)
+
+			n := atomic.AddInt32(&s.issued, 1)
+			s.token = fmt.Sprint("token", n)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  s.token,
+				"token_type":    "bearer",
+				"refresh_token": "refresh",
+				"expires_in":    s.expiresIn,
+			})
+		case "/revoke":
+			s.token = ""
+		default:
+			if r.Header.Get("Authorization") != "Bearer "+s.token {
+				w.WriteHeader(401)
+				return
+			}
+			io.WriteString(w, r.Header.Get("Authorization"))
+		}
+	}))
+
+	return s
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	server := newOAuth2TestServer(3600)
+	defer server.Close()
+
+	source := NewOAuth2TokenSource(OAuth2Config{
+		TokenURL:     server.URL + "/token",
+		ClientID:     "id",
+		ClientSecret: "secret",
+	})
+	c := NewHttpClient().Defaults(Map{
+		OPT_OAUTH2: source,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Begin().Get(server.URL + "/api")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if body, _ := res.ToString(); body != "Bearer token1" {
+				t.Error("token is not attached:", res.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if issued := atomic.LoadInt32(&server.issued); issued != 1 {
+		t.Error("token should be shared, issued:", issued)
+	}
+
+	// rejected token should be refreshed, and the request retried
+	NewHttpClient().Get(server.URL + "/revoke")
+	res, err := c.Post(server.URL+"/api", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); body != "Bearer token2" {
+		t.Error("token is not refreshed:", res.StatusCode, body)
+	}
+
+	if server.grants[1] != GRANT_REFRESH_TOKEN {
+		t.Error("refresh token is not used:", server.grants)
+	}
+}
+
+func TestOAuth2Password(t *testing.T) {
+	// expired immediately
+	server := newOAuth2TestServer(1)
+	defer server.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_OAUTH2: NewOAuth2TokenSource(OAuth2Config{
+			TokenURL:     server.URL + "/token",
+			ClientID:     "id",
+			ClientSecret: "secret",
+			GrantType:    GRANT_PASSWORD,
+			Username:     "user",
+			Password:     "pass",
+		}),
+	})
+
+	for i := 1; i <= 2; i++ {
+		res, err := c.Get(server.URL + "/api")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body, _ := res.ToString(); body != fmt.Sprint("Bearer token", i) {
+			t.Error("expired token is not refreshed:", res.StatusCode, body)
+		}
+	}
+
+	if len(server.grants) != 2 || server.grants[0] != GRANT_PASSWORD || server.grants[1] != GRANT_REFRESH_TOKEN {
+		t.Error("wrong grants:", server.grants)
+	}
+}
+
+func TestOAuth2Error(t *testing.T) {
+	server := newOAuth2TestServer(3600)
+	defer server.Close()
+
+	_, err := NewHttpClient().
+		WithOption(OPT_OAUTH2, NewOAuth2TokenSource(OAuth2Config{
+			TokenURL:     server.URL + "/token",
+			ClientID:     "id",
+			ClientSecret: "wrong",
+		})).
+		Get(server.URL + "/api")
+
+	if getErrorCode(err) != ERR_OAUTH2 {
+		t.Error("expected ERR_OAUTH2:", err)
+	}
+}
+
+func TestOAuth2Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the token endpoint hangs
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	source := NewOAuth2TokenSource(OAuth2Config{
+		TokenURL: server.URL + "/token",
+	})
+
+	// one request is fetching the token, others are waiting for it
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			c := NewHttpClient().WithOption(OPT_OAUTH2, source)
+			if i == 0 {
+				c.WithOption(OPT_TIMEOUT_MS, 100)
+			} else {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				c.WithOption(OPT_CONTEXT, ctx)
+			}
+
+			if _, err := c.Get(server.URL + "/api"); err == nil {
+				t.Error("request should time out")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error("requests should not wait for the token endpoint:", elapsed)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Function of OPT_PROGRESSFUNCTION, similar to curl's xferinfo function.
+// Totals are -1 if unknown. Return an error to abort the transfer.
+type ProgressFunc func(dltotal, dlnow, ultotal, ulnow int64) error
+
+// Minimum interval between progress calls, except the last ones of upload
+// and download.
+const progressInterval = 100 * time.Millisecond
+
+// Progress of a request, the function is never called concurrently.
+type progress struct {
+	lock sync.Mutex
+	fn   ProgressFunc
+
+	dltotal, dlnow int64
+	ultotal, ulnow int64
+
+	last time.Time
+
+	// The transfer is aborted.
+	err error
+}
+
+// Add transferred bytes, the function is called if it's time.
+func (this *progress) add(upload bool, n int64, done bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.err != nil {
+		return this.err
+	}
+
+	if upload {
+		this.ulnow += n
+	} else {
+		this.dlnow += n
+	}
+
+	now := time.Now()
+	if !done && now.Sub(this.last) < progressInterval {
+		return nil
+	}
+	this.last = now
+
+	if err := this.fn(this.dltotal, this.dlnow, this.ultotal, this.ulnow); err != nil {
+		this.err = err
+	}
+
+	return this.err
+}
+
+// Start a transfer(requests of redirects and retries start over).
+func (this *progress) start(ultotal int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.ultotal = ultotal
+	this.ulnow = 0
+	this.dltotal = -1
+	this.dlnow = 0
+}
+
+func (this *progress) setDownloadTotal(dltotal int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.dltotal = dltotal
+}
+
+// A RoundTripper reports the progress of request and response bodies.
+type progressTransport struct {
+	transport http.RoundTripper
+	progress  *progress
+}
+
+// Implement http.RoundTripper.
+func (this *progressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		this.progress.start(0)
+	} else {
+		ultotal := req.ContentLength
+		if ultotal == 0 {
+			ultotal = -1
+		}
+		this.progress.start(ultotal)
+
+		req = req.Clone(req.Context())
+		req.Body = &progressReader{req.Body, this.progress, true}
+	}
+
+	res, err := this.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	this.progress.setDownloadTotal(res.ContentLength)
+	res.Body = &progressReader{res.Body, this.progress, false}
+
+	return res, nil
+}
+
+// A body which reports the progress.
+type progressReader struct {
+	io.ReadCloser
+	progress *progress
+	upload   bool
+}
+
+func (this *progressReader) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+	if perr := this.progress.add(this.upload, int64(n), err == io.EOF); perr != nil {
+		return n, perr
+	}
+
+	return n, err
+}
+
+// Prepare the progress with OPT_PROGRESSFUNCTION.
+func prepareProgress(options map[int]interface{}) (*progress, error) {
+	fn_, ok := options[OPT_PROGRESSFUNCTION]
+	if !ok || fn_ == nil {
+		return nil, nil
+	}
+
+	var fn ProgressFunc
+	switch f := fn_.(type) {
+	case ProgressFunc:
+		fn = f
+	case func(dltotal, dlnow, ultotal, ulnow int64) error:
+		fn = f
+	default:
+		return nil, fmt.Errorf("OPT_PROGRESSFUNCTION must be httpclient.ProgressFunc")
+	}
+
+	return &progress{
+		fn: fn,
+	}, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.Header().Set("Content-Length", "100000")
+		w.Write(bytes.Repeat([]byte("a"), 100000))
+	}))
+	defer server.Close()
+
+	var calls int
+	var last [4]int64
+	c := NewHttpClient().Defaults(Map{
+		OPT_PROGRESSFUNCTION: func(dltotal, dlnow, ultotal, ulnow int64) error {
+			calls++
+			last = [4]int64{dltotal, dlnow, ultotal, ulnow}
+			return nil
+		},
+	})
+
+	res, err := c.Put(server.URL, bytes.NewReader(bytes.Repeat([]byte("b"), 50000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := res.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if last != [4]int64{100000, 100000, 50000, 50000} {
+		t.Error("wrong progress:", last)
+	}
+
+	// calls are rate limited, the last calls of upload and download are
+	// always made
+	if calls < 2 || calls > 10 {
+		t.Error("wrong number of calls:", calls)
+	}
+
+	// unknown upload size
+	res, err = c.Post(server.URL, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	if last[2] != 3 || last[3] != 3 {
+		t.Error("wrong upload progress:", last)
+	}
+
+	res, err = c.PostMultipart(server.URL, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	if last[3] <= 0 || last[2] != last[3] {
+		t.Error("wrong upload progress:", last)
+	}
+}
+
+func TestProgressAbort(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.Write(bytes.Repeat([]byte("a"), 100000))
+	}))
+	defer server.Close()
+
+	aborted := errors.New("aborted")
+
+	// download
+	res, err := NewHttpClient().
+		WithOption(OPT_PROGRESSFUNCTION, ProgressFunc(func(dltotal, dlnow, ultotal, ulnow int64) error {
+			if dlnow > 0 {
+				return aborted
+			}
+			return nil
+		})).
+		Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := res.ReadAll(); err != aborted {
+		t.Error("download should be aborted:", err)
+	}
+
+	// upload
+	_, err = NewHttpClient().
+		WithOption(OPT_PROGRESSFUNCTION, func(dltotal, dlnow, ultotal, ulnow int64) error {
+			if ulnow > 0 {
+				return aborted
+			}
+			return nil
+		}).
+		Put(server.URL, bytes.NewReader(make([]byte, 1<<20)))
+	if !errors.Is(err, aborted) {
+		t.Error("upload should be aborted:", err)
+	}
+
+	if _, err := NewHttpClient().WithOption(OPT_PROGRESSFUNCTION, 1).Get(server.URL); err == nil {
+		t.Error("invalid progress function should fail")
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http/cookiejar"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Flags of public suffix rules.
+const (
+	pslRuleNormal = 1 << iota
+	pslRuleWildcard
+	pslRuleException
+)
+
+// A public suffix list in the format of https://publicsuffix.org/list/, it
+// implements cookiejar.PublicSuffixList.
+//
+// Rules of both the ICANN and the private sections are used(as browsers do),
+// and "*" is the implicit rule, so unknown top level domains are public
+// suffixes.
+type SuffixList struct {
+	name string
+
+	// Flags of rules without the "*." or "!" prefix, in ASCII(punycode).
+	rules map[string]int
+}
+
+//go:generate go run psl_gen.go
+
+// The built-in public suffix list, see suffixListData.
+var DefaultPublicSuffixList = mustParseSuffixList("built-in", suffixListData)
+
+func mustParseSuffixList(name string, data string) *SuffixList {
+	list, err := ParsePublicSuffixList(name, strings.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	return list
+}
+
+// Parse a public suffix list, name is returned by String().
+func ParsePublicSuffixList(name string, r io.Reader) (*SuffixList, error) {
+	list := &SuffixList{
+		name:  name,
+		rules: make(map[string]int),
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		// only the first word is the rule
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			line = line[:i]
+		}
+
+		kind := pslRuleNormal
+		if strings.HasPrefix(line, "!") {
+			kind = pslRuleException
+			line = line[1:]
+		} else if strings.HasPrefix(line, "*.") {
+			kind = pslRuleWildcard
+			line = line[2:]
+		}
+
+		rule, err := toASCIIDomain(strings.ToLower(line))
+		if err != nil || rule == "" {
+			return nil, fmt.Errorf("invalid public suffix rule at line %d: %s", lineno, line)
+		}
+
+		list.rules[rule] |= kind
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Load a public suffix list file(e.g. public_suffix_list.dat).
+func LoadPublicSuffixList(path string) (*SuffixList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParsePublicSuffixList(path, f)
+}
+
+// Implement cookiejar.PublicSuffixList.
+func (this *SuffixList) PublicSuffix(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	labels := strings.Split(domain, ".")
+
+	// the longest matching rule prevails, exceptions are always longer than
+	// the wildcards they belong to
+	for i := 0; i < len(labels); i++ {
+		candidate := strings.Join(labels[i:], ".")
+		flags := this.rules[candidate]
+		if flags&pslRuleException != 0 {
+			return strings.Join(labels[i+1:], ".")
+		}
+
+		if flags&pslRuleNormal != 0 {
+			return candidate
+		}
+
+		if i+1 < len(labels) && this.rules[strings.Join(labels[i+1:], ".")]&pslRuleWildcard != 0 {
+			return candidate
+		}
+	}
+
+	// the implicit "*" rule
+	return labels[len(labels)-1]
+}
+
+// Implement cookiejar.PublicSuffixList.
+func (this *SuffixList) String() string {
+	return this.name
+}
+
+// Whether the domain is a public suffix.
+func (this *SuffixList) IsPublicSuffix(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return this.PublicSuffix(domain) == domain
+}
+
+// The registrable domain(public suffix plus one label), empty if the domain
+// itself is a public suffix.
+func EffectiveTLDPlusOne(list cookiejar.PublicSuffixList, domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	suffix := list.PublicSuffix(domain)
+	if len(domain) <= len(suffix) {
+		return ""
+	}
+
+	i := strings.LastIndex(domain[:len(domain)-len(suffix)-1], ".")
+	return domain[i+1:]
+}
+
+// Prepare the public suffix list of the default jar with
+// OPT_PUBLIC_SUFFIX_LIST.
+func preparePublicSuffixList(options map[int]interface{}) (cookiejar.PublicSuffixList, error) {
+	list_, ok := options[OPT_PUBLIC_SUFFIX_LIST]
+	if !ok || list_ == nil {
+		return DefaultPublicSuffixList, nil
+	}
+
+	switch list := list_.(type) {
+	case string:
+		return LoadPublicSuffixList(list)
+	case cookiejar.PublicSuffixList:
+		return list, nil
+	}
+
+	return nil, fmt.Errorf("OPT_PUBLIC_SUFFIX_LIST must be string or cookiejar.PublicSuffixList")
+}
+
+// Convert labels of an internationalized domain to punycode.
+func toASCIIDomain(domain string) (string, error) {
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("empty label")
+		}
+
+		if !isASCII(label) {
+			encoded, err := punycodeEncode(label)
+			if err != nil {
+				return "", err
+			}
+			labels[i] = "xn--" + encoded
+		}
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Punycode encoding of RFC 3492.
+func punycodeEncode(s string) (string, error) {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		skew        = 38
+		damp        = 700
+		initialBias = 72
+		initialN    = 128
+	)
+
+	adapt := func(delta, numPoints int32, first bool) int32 {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / numPoints
+		k := int32(0)
+		for delta > ((base-tmin)*tmax)/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+
+	digit := func(d int32) byte {
+		if d < 26 {
+			return byte('a' + d)
+		}
+		return byte('0' + d - 26)
+	}
+
+	runes := []rune(s)
+	output := make([]byte, 0, len(s))
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			output = append(output, byte(r))
+		}
+	}
+
+	b := int32(len(output))
+	h := b
+	if b > 0 {
+		output = append(output, '-')
+	}
+
+	n := int32(initialN)
+	delta := int32(0)
+	bias := int32(initialBias)
+	for h < int32(len(runes)) {
+		m := int32(0x7fffffff)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		if (m-n)*(h+1) < 0 {
+			return "", fmt.Errorf("punycode overflow")
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+
+			if r == n {
+				q := delta
+				for k := int32(base); ; k += base {
+					t := k - bias
+					if t < tmin {
+						t = tmin
+					} else if t > tmax {
+						t = tmax
+					}
+					if q < t {
+						break
+					}
+					output = append(output, digit(t+(q-t)%(base-t)))
+					q = (q - t) / (base - t)
+				}
+				output = append(output, digit(q))
+				bias = adapt(delta, h+1, h == b)
+				delta = 0
+				h++
+			}
+		}
+
+		delta++
+		n++
+	}
+
+	return string(output), nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+//go:build ignore
+// +build ignore
+
+// Generate psl_data.go from the public suffix list.
+//
+//	go run psl_gen.go [public_suffix_list.dat]
+//
+// The list is downloaded from publicsuffix.org if no file is given.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const pslURL = "https://publicsuffix.org/list/public_suffix_list.dat"
+
+func main() {
+	var data []byte
+	var err error
+	if len(os.Args) > 1 {
+		data, err = ioutil.ReadFile(os.Args[1])
+	} else {
+		data, err = download(pslURL)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if bytes.IndexByte(data, '`') >= 0 {
+		log.Fatal("the list contains a backquote")
+	}
+
+	if !bytes.Contains(data, []byte("===BEGIN ICANN DOMAINS===")) ||
+		!bytes.Contains(data, []byte("===END PRIVATE DOMAINS===")) {
+		log.Fatal("the list is not complete")
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+// Code generated by psl_gen.go; DO NOT EDIT.
+
+package httpclient
+
+// The built-in public suffix list, a copy of
+// https://publicsuffix.org/list/public_suffix_list.dat(licensed under the
+// MPL 2.0). Regenerate it with "go generate", or load a newer list with
+// OPT_PUBLIC_SUFFIX_LIST or LoadPublicSuffixList.
+const suffixListData = ` + "`\n")
+	buf.WriteString(strings.TrimSpace(string(data)))
+	buf.WriteString("\n`\n")
+
+	if err := ioutil.WriteFile("psl_data.go", buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func download(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to download %s: %s", url, res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
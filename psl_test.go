@@ -0,0 +1,155 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPublicSuffix(t *testing.T) {
+	cases := map[string]string{
+		"com":                       "com",
+		"example.com":               "com",
+		"www.example.com":           "com",
+		"example.co.uk":             "co.uk",
+		"www.example.co.uk":         "co.uk",
+		"example.unknowntld":        "unknowntld",
+		"github.io":                 "github.io",
+		"user.github.io":            "github.io",
+		"ck":                        "ck",
+		"test.ck":                   "test.ck",
+		"b.test.ck":                 "test.ck",
+		"www.ck":                    "ck",
+		"www.www.ck":                "ck",
+		"city.kobe.jp":              "kobe.jp",
+		"www.city.kobe.jp":          "kobe.jp",
+		"c.kobe.jp":                 "c.kobe.jp",
+		"b.c.kobe.jp":               "c.kobe.jp",
+		"xn--55qx5d.cn":             "xn--55qx5d.cn",
+		"shishi.xn--55qx5d.cn":      "xn--55qx5d.cn",
+		"www.example.com.":          "com",
+		"WWW.Example.CO.UK":         "co.uk",
+		"ec2.compute.amazonaws.com": "ec2.compute.amazonaws.com",
+		"bank.com.tr":               "com.tr",
+		"shop.com.ua":               "com.ua",
+		"www.example.com.pl":        "com.pl",
+		"example.com.br":            "com.br",
+	}
+
+	for domain, expected := range cases {
+		if suffix := DefaultPublicSuffixList.PublicSuffix(domain); suffix != expected {
+			t.Errorf("wrong public suffix of %s: %s", domain, suffix)
+		}
+	}
+
+	etld1 := map[string]string{
+		"co.uk":             "",
+		"example.co.uk":     "example.co.uk",
+		"www.example.co.uk": "example.co.uk",
+		"a.b.example.com":   "example.com",
+		"user.github.io":    "user.github.io",
+	}
+
+	for domain, expected := range etld1 {
+		if v := EffectiveTLDPlusOne(DefaultPublicSuffixList, domain); v != expected {
+			t.Errorf("wrong eTLD+1 of %s: %s", domain, v)
+		}
+	}
+}
+
+func TestPunycode(t *testing.T) {
+	cases := map[string]string{
+		"公司":      "55qx5d",
+		"bücher":  "bcher-kva",
+		"münchen": "mnchen-3ya",
+	}
+
+	for s, expected := range cases {
+		if v, _ := punycodeEncode(s); v != expected {
+			t.Errorf("wrong punycode of %s: %s", s, v)
+		}
+	}
+}
+
+func TestJarPublicSuffix(t *testing.T) {
+	jar := NewJar()
+	u, _ := url.Parse("http://www.example.co.uk/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "suffix", Value: "1", Domain: "co.uk"},
+		{Name: "domain", Value: "1", Domain: "example.co.uk"},
+	})
+
+	if names := jarCookieNames(jar, "http://other.co.uk/"); names != "" {
+		t.Error("cookies of public suffix should be rejected:", names)
+	}
+
+	if names := jarCookieNames(jar, "http://example.co.uk/"); names != "domain" {
+		t.Error("wrong cookies:", names)
+	}
+
+	// the host itself is a public suffix
+	u, _ = url.Parse("http://user.github.io/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1", Domain: "user.github.io"},
+		{Name: "suffix", Value: "1", Domain: "github.io"},
+	})
+
+	if names := jarCookieNames(jar, "http://user.github.io/"); names != "host" {
+		t.Error("wrong cookies:", names)
+	}
+
+	if names := jarCookieNames(jar, "http://other.github.io/"); names != "" {
+		t.Error("cookies of public suffix should be rejected:", names)
+	}
+
+	u, _ = url.Parse("http://evil.com.tr/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "suffix", Value: "1", Domain: "com.tr"}})
+
+	if names := jarCookieNames(jar, "http://bank.com.tr/"); names != "" {
+		t.Error("cookies of public suffix should be rejected:", names)
+	}
+}
+
+func TestLoadPublicSuffixList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "public_suffix_list.dat")
+	ioutil.WriteFile(path, []byte("// comment\ncom\nexample.com\n*.wild.com\n!safe.wild.com\n"), 0600)
+
+	list, err := LoadPublicSuffixList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list.String() != path || !list.IsPublicSuffix("example.com") || !list.IsPublicSuffix("x.wild.com") || list.IsPublicSuffix("safe.wild.com") {
+		t.Error("wrong public suffix list")
+	}
+
+	jar, err := prepareJar(map[int]interface{}{
+		OPT_COOKIEJAR:          true,
+		OPT_PUBLIC_SUFFIX_LIST: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("http://a.example.com/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1", Domain: "example.com"}})
+	if names := jarCookieNames(jar, "http://b.example.com/"); names != "" {
+		t.Error("the loaded list is not used:", names)
+	}
+
+	if _, err := prepareJar(map[int]interface{}{OPT_PUBLIC_SUFFIX_LIST: 1}); err == nil {
+		t.Error("invalid list should fail")
+	}
+}
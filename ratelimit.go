@@ -0,0 +1,196 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Buckets are pruned when there are more of them.
+const rateLimitPruneThreshold = 1024
+
+// A token bucket rate limiter keyed by host, see OPT_RATE_LIMIT. It can be
+// shared by clients.
+type RateLimiter struct {
+	// Key of the bucket of a request, the host(with port) by default.
+	KeyFunc func(req *http.Request) string
+
+	lock sync.Mutex
+
+	// Tokens per second.
+	rate  float64
+	burst int
+
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	// Negative tokens are reserved by waiting requests.
+	tokens float64
+	last   time.Time
+}
+
+// Create a rate limiter allows rate requests per second of every host, with
+// bursts of at most burst requests.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (this *RateLimiter) key(req *http.Request) string {
+	if this.KeyFunc != nil {
+		return this.KeyFunc(req)
+	}
+
+	return req.URL.Host
+}
+
+// Take a token of the key, returns how long to wait for it.
+func (this *RateLimiter) reserve(key string, now time.Time) time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.buckets) > rateLimitPruneThreshold {
+		this.prune(now)
+	}
+
+	bucket, ok := this.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens: float64(this.burst),
+			last:   now,
+		}
+		this.buckets[key] = bucket
+	}
+
+	this.refill(bucket, now)
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-bucket.tokens / this.rate * float64(time.Second))
+}
+
+// Return a token which is not used.
+func (this *RateLimiter) cancel(key string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if bucket, ok := this.buckets[key]; ok {
+		bucket.tokens++
+	}
+}
+
+// Must be called with the lock.
+func (this *RateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	if now.After(bucket.last) {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * this.rate
+		bucket.last = now
+	}
+
+	if bucket.tokens > float64(this.burst) {
+		bucket.tokens = float64(this.burst)
+	}
+}
+
+// Remove full buckets, they are the same as new ones. Must be called with the
+// lock.
+func (this *RateLimiter) prune(now time.Time) {
+	for key, bucket := range this.buckets {
+		this.refill(bucket, now)
+		if bucket.tokens >= float64(this.burst) {
+			delete(this.buckets, key)
+		}
+	}
+}
+
+// Wait until the request is allowed or the context is done.
+func (this *RateLimiter) Wait(ctx context.Context, req *http.Request) error {
+	if this.rate <= 0 {
+		return nil
+	}
+
+	key := this.key(req)
+	wait := this.reserve(key, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		this.cancel(key)
+		return ctx.Err()
+	}
+}
+
+// The request is not sent, the context is done while waiting for the rate
+// limiter.
+type rateLimitWaitError struct {
+	err error
+}
+
+func (this *rateLimitWaitError) Error() string {
+	return this.err.Error()
+}
+
+func (this *rateLimitWaitError) Unwrap() error {
+	return this.err
+}
+
+// Implement net.Error, so that it's a timeout if the deadline is exceeded.
+func (this *rateLimitWaitError) Timeout() bool {
+	return errors.Is(this.err, context.DeadlineExceeded)
+}
+
+func (this *rateLimitWaitError) Temporary() bool {
+	return false
+}
+
+// A RoundTripper waits for the rate limiter before sending requests.
+type rateLimitTransport struct {
+	transport http.RoundTripper
+	limiter   *RateLimiter
+}
+
+// Implement http.RoundTripper.
+func (this *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := this.limiter.Wait(req.Context(), req); err != nil {
+		return nil, &rateLimitWaitError{err}
+	}
+
+	return this.transport.RoundTrip(req)
+}
+
+// Prepare the rate limiter with OPT_RATE_LIMIT.
+func prepareRateLimiter(options map[int]interface{}) (*RateLimiter, error) {
+	limiter_, ok := options[OPT_RATE_LIMIT]
+	if !ok || limiter_ == nil {
+		return nil, nil
+	}
+
+	limiter, ok := limiter_.(*RateLimiter)
+	if !ok {
+		return nil, fmt.Errorf("OPT_RATE_LIMIT must be *httpclient.RateLimiter")
+	}
+
+	return limiter, nil
+}
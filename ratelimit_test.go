@@ -0,0 +1,100 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(10, 2)
+	now := time.Now()
+
+	for i, expected := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if wait := limiter.reserve("a", now); wait != expected {
+			t.Error("wrong wait:", i, wait)
+		}
+	}
+
+	// other keys are not affected
+	if wait := limiter.reserve("b", now); wait != 0 {
+		t.Error("wrong wait:", wait)
+	}
+
+	// refilled
+	if wait := limiter.reserve("a", now.Add(time.Second)); wait != 0 {
+		t.Error("wrong wait:", wait)
+	}
+
+	limiter.prune(now.Add(time.Hour))
+	if len(limiter.buckets) != 0 {
+		t.Error("full buckets should be pruned")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(20, 1)
+	c := NewHttpClient().Defaults(Map{
+		OPT_RATE_LIMIT: limiter,
+	})
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Begin().Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Error("requests are not limited:", elapsed)
+	}
+
+	// another host, its bucket is not drained by the requests above
+	other := httptest.NewRequest("GET", strings.Replace(server.URL, "127.0.0.1", "localhost", 1), nil)
+	if wait := limiter.reserve(limiter.key(other), time.Now()); wait != 0 {
+		t.Error("hosts should be limited separately:", wait)
+	}
+
+	// canceled while waiting
+	slow := NewRateLimiter(0.1, 1)
+	c = NewHttpClient().Defaults(Map{
+		OPT_RATE_LIMIT: slow,
+	})
+	res, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	_, err = c.WithOption(OPT_CONTEXT, ctx).Get(server.URL)
+	if err == nil || time.Since(start) > time.Second {
+		t.Error("waiting should be canceled with the context")
+	}
+
+	if wait := slow.reserve(slow.key(httptest.NewRequest("GET", server.URL, nil)), time.Now()); wait > 11*time.Second {
+		t.Error("token of the canceled request should be returned:", wait)
+	}
+}
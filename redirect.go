@@ -0,0 +1,339 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Values of OPT_REDIRECT_HEADERS, it can also be a list of headers to
+// forward.
+const (
+	// Headers of the request are forwarded.
+	REDIRECT_HEADERS_ALL = iota
+
+	// Only the User-Agent is forwarded(and the Content-Type if the body is
+	// sent again).
+	REDIRECT_HEADERS_NONE
+)
+
+// Bits of OPT_POSTREDIR, similar to CURL_REDIR_POST_*.
+const (
+	// POST stays POST on 301.
+	REDIR_POST_301 = 1 << iota
+
+	// POST stays POST on 302.
+	REDIR_POST_302
+
+	// POST stays POST on 303.
+	REDIR_POST_303
+
+	REDIR_POST_ALL = REDIR_POST_301 | REDIR_POST_302 | REDIR_POST_303
+)
+
+// A redirect followed by a request.
+type Redirect struct {
+	// The request redirected.
+	Method string
+	URL    string
+
+	// The redirect response.
+	StatusCode int
+	Location   string
+	Header     http.Header
+}
+
+// Headers carry credentials, they are not forwarded to other origins.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// Prepare a redirect policy.
+//
+// Besides the policy of OPT_REDIRECT_POLICY(or OPT_FOLLOWLOCATION and
+// OPT_MAXREDIRS), headers are forwarded with OPT_REDIRECT_HEADERS,
+// credentials are stripped on cross-origin redirects unless
+// OPT_UNRESTRICTED_AUTH is set, and redirects from https to http are refused
+// unless OPT_REDIRECT_DOWNGRADE is set. The Referer is set with
+// OPT_AUTOREFERER, and POST is kept with OPT_POSTREDIR.
+func prepareRedirect(options map[int]interface{}) (func(req *http.Request, via []*http.Request) error, error) {
+	redirectPolicy, err := prepareRedirectPolicy(options)
+	if err != nil {
+		return nil, err
+	}
+
+	forward, err := prepareRedirectHeaders(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var unrestricted bool
+	if unrestricted_, ok := options[OPT_UNRESTRICTED_AUTH]; ok {
+		if unrestricted, ok = unrestricted_.(bool); !ok {
+			return nil, fmt.Errorf("OPT_UNRESTRICTED_AUTH must be bool")
+		}
+	}
+
+	var downgrade bool
+	if downgrade_, ok := options[OPT_REDIRECT_DOWNGRADE]; ok {
+		if downgrade, ok = downgrade_.(bool); !ok {
+			return nil, fmt.Errorf("OPT_REDIRECT_DOWNGRADE must be bool")
+		}
+	}
+
+	var autoreferer bool
+	if autoreferer_, ok := options[OPT_AUTOREFERER]; ok {
+		if autoreferer, ok = autoreferer_.(bool); !ok {
+			return nil, fmt.Errorf("OPT_AUTOREFERER must be bool")
+		}
+	}
+
+	var postredir int
+	if postredir_, ok := options[OPT_POSTREDIR]; ok {
+		if postredir, ok = postredir_.(int); !ok {
+			return nil, fmt.Errorf("OPT_POSTREDIR must be int")
+		}
+	}
+
+	return func(req *http.Request, via []*http.Request) error {
+		last := via[len(via)-1]
+
+		// POST is changed to GET by the standard library on 301, 302 and 303
+		if last.Method == "POST" && req.Method == "GET" && req.Response != nil &&
+			keepPost(postredir, req.Response.StatusCode) {
+			req.Method = last.Method
+			if err := replayBody(req, via[0]); err != nil {
+				return err
+			}
+			if contentType := last.Header.Get("Content-Type"); contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+		}
+
+		// headers of the request are copied by the standard library, except
+		// credentials for other domains
+		if forward != nil {
+			header := make(http.Header)
+			for _, name := range forward {
+				if values, ok := req.Header[http.CanonicalHeaderKey(name)]; ok {
+					header[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+
+			if req.GetBody != nil {
+				if values, ok := req.Header["Content-Type"]; ok {
+					header["Content-Type"] = values
+				}
+			}
+
+			req.Header = header
+		}
+
+		if unrestricted {
+			// restore the credentials stripped by the standard library
+			authorization := via[0].Header.Get("Authorization")
+			if authorization != "" && req.Header.Get("Authorization") == "" &&
+				(forward == nil || hasHeader(forward, "Authorization")) {
+				req.Header.Set("Authorization", authorization)
+			}
+		} else if !sameOrigin(req.URL, via[0].URL) {
+			for _, name := range credentialHeaders {
+				req.Header.Del(name)
+			}
+		}
+
+		// the standard library sets the Referer
+		if !autoreferer {
+			if referer := via[0].Header.Get("Referer"); referer != "" {
+				req.Header.Set("Referer", referer)
+			} else {
+				req.Header.Del("Referer")
+			}
+		} else if referer := refererOf(last.URL, req.URL); referer != "" {
+			req.Header.Set("Referer", referer)
+		}
+
+		if err := redirectPolicy(req, via); err != nil {
+			return err
+		}
+
+		if !downgrade && last.URL.Scheme == "https" && req.URL.Scheme == "http" {
+			return &Error{
+				Code:    ERR_REDIRECT_POLICY,
+				Message: fmt.Sprintf("redirect from https to http is not allowed: %s", req.URL),
+			}
+		}
+
+		redirect := &Redirect{
+			Method: last.Method,
+			URL:    last.URL.String(),
+		}
+		if req.Response != nil {
+			redirect.StatusCode = req.Response.StatusCode
+			redirect.Location = req.Response.Header.Get("Location")
+			redirect.Header = req.Response.Header
+		}
+
+		state := getRequestState(req)
+		state.redirects = append(state.redirects, redirect)
+
+		return nil
+	}, nil
+}
+
+// Prepare the policy of OPT_REDIRECT_POLICY, or the default policy with
+// OPT_FOLLOWLOCATION and OPT_MAXREDIRS.
+func prepareRedirectPolicy(options map[int]interface{}) (func(req *http.Request, via []*http.Request) error, error) {
+	var redirectPolicy func(req *http.Request, via []*http.Request) error
+
+	if redirectPolicy_, ok := options[OPT_REDIRECT_POLICY]; ok {
+		if redirectPolicy, ok = redirectPolicy_.(func(*http.Request, []*http.Request) error); !ok {
+			return nil, fmt.Errorf("OPT_REDIRECT_POLICY is not a desired function")
+		}
+	} else {
+		var followlocation bool
+		if followlocation_, ok := options[OPT_FOLLOWLOCATION]; ok {
+			if followlocation, ok = followlocation_.(bool); !ok {
+				return nil, fmt.Errorf("OPT_FOLLOWLOCATION must be bool")
+			}
+		}
+
+		var maxredirs int
+		if maxredirs_, ok := options[OPT_MAXREDIRS]; ok {
+			if maxredirs, ok = maxredirs_.(int); !ok {
+				return nil, fmt.Errorf("OPT_MAXREDIRS must be int")
+			}
+		}
+
+		redirectPolicy = func(req *http.Request, via []*http.Request) error {
+			// no follow
+			if !followlocation || maxredirs <= 0 {
+				return &Error{
+					Code:    ERR_REDIRECT_POLICY,
+					Message: fmt.Sprintf("redirect not allowed"),
+				}
+			}
+
+			if len(via) >= maxredirs {
+				return &Error{
+					Code:    ERR_REDIRECT_POLICY,
+					Message: fmt.Sprintf("stopped after %d redirects", len(via)),
+				}
+			}
+
+			return nil
+		}
+	}
+
+	return redirectPolicy, nil
+}
+
+// Prepare headers to forward with OPT_REDIRECT_HEADERS, nil means all.
+func prepareRedirectHeaders(options map[int]interface{}) ([]string, error) {
+	headers_, ok := options[OPT_REDIRECT_HEADERS]
+	if !ok || headers_ == nil {
+		return nil, nil
+	}
+
+	switch headers := headers_.(type) {
+	case int:
+		switch headers {
+		case REDIRECT_HEADERS_ALL:
+			return nil, nil
+		case REDIRECT_HEADERS_NONE:
+			return []string{"User-Agent"}, nil
+		}
+	case []string:
+		return append([]string{"User-Agent"}, headers...), nil
+	}
+
+	return nil, fmt.Errorf("OPT_REDIRECT_HEADERS must be REDIRECT_HEADERS_ALL, REDIRECT_HEADERS_NONE or []string")
+}
+
+// Whether POST stays POST on the status.
+func keepPost(postredir int, status int) bool {
+	switch status {
+	case 301:
+		return postredir&REDIR_POST_301 != 0
+	case 302:
+		return postredir&REDIR_POST_302 != 0
+	case 303:
+		return postredir&REDIR_POST_303 != 0
+	}
+
+	return false
+}
+
+// Send the body of the original request again with the redirect.
+func replayBody(req *http.Request, original *http.Request) error {
+	if original.GetBody == nil {
+		if original.Body == nil || original.Body == http.NoBody {
+			return nil
+		}
+
+		return &Error{
+			Code:    ERR_REDIRECT_POLICY,
+			Message: "the body can not be sent again with the redirect",
+		}
+	}
+
+	body, err := original.GetBody()
+	if err != nil {
+		return err
+	}
+
+	req.Body = body
+	req.GetBody = original.GetBody
+	req.ContentLength = original.ContentLength
+
+	return nil
+}
+
+// The Referer of a redirect, without credentials and the fragment. It's empty
+// from https to http.
+func refererOf(from *url.URL, to *url.URL) string {
+	if from.Scheme == "https" && to.Scheme == "http" {
+		return ""
+	}
+
+	u := *from
+	u.User = nil
+	u.Fragment = ""
+
+	return u.String()
+}
+
+func hasHeader(headers []string, name string) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether the urls have the same scheme, host and port.
+func sameOrigin(a *url.URL, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		urlPort(a) == urlPort(b)
+}
+
+// Port of the url, or the default port of the scheme.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+
+	return ""
+}
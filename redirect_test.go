@@ -0,0 +1,322 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirectHeaders(t *testing.T) {
+	var received http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer target.Close()
+
+	var sameOriginReceived http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cross":
+			http.Redirect(w, r, target.URL+"/target", 302)
+		case "/same":
+			http.Redirect(w, r, "/target", 302)
+		default:
+			sameOriginReceived = r.Header
+		}
+	}))
+	defer server.Close()
+
+	headers := map[string]string{
+		"X-Custom":      "custom",
+		"Authorization": "Bearer secret",
+		"Cookie":        "a=1",
+	}
+
+	// all headers are forwarded, except credentials to other origins
+	if _, err := NewHttpClient().Do("GET", server.URL+"/cross", headers, nil); err != nil {
+		t.Fatal(err)
+	}
+	if received.Get("X-Custom") != "custom" || received.Get("User-Agent") != USERAGENT {
+		t.Error("headers should be forwarded:", received)
+	}
+	if received.Get("Authorization") != "" || received.Get("Cookie") != "" {
+		t.Error("credentials should be stripped:", received)
+	}
+
+	if _, err := NewHttpClient().Do("GET", server.URL+"/same", headers, nil); err != nil {
+		t.Fatal(err)
+	}
+	if sameOriginReceived.Get("Authorization") != "Bearer secret" || sameOriginReceived.Get("Cookie") != "a=1" {
+		t.Error("credentials should be forwarded to the same origin:", sameOriginReceived)
+	}
+
+	// unrestricted
+	_, err := NewHttpClient().
+		WithOption(OPT_UNRESTRICTED_AUTH, true).
+		Do("GET", server.URL+"/cross", headers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Get("Authorization") != "Bearer secret" {
+		t.Error("authorization should be forwarded:", received)
+	}
+
+	// none
+	_, err = NewHttpClient().
+		WithOption(OPT_REDIRECT_HEADERS, REDIRECT_HEADERS_NONE).
+		Do("GET", server.URL+"/cross", headers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Get("X-Custom") != "" || received.Get("User-Agent") != USERAGENT {
+		t.Error("only the user agent should be forwarded:", received)
+	}
+
+	// allowlist
+	_, err = NewHttpClient().
+		WithOption(OPT_REDIRECT_HEADERS, []string{"x-custom"}).
+		Do("GET", server.URL+"/cross", map[string]string{
+			"X-Custom": "custom",
+			"X-Other":  "other",
+		}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Get("X-Custom") != "custom" || received.Get("X-Other") != "" {
+		t.Error("only allowed headers should be forwarded:", received)
+	}
+
+	if _, err := NewHttpClient().WithOption(OPT_REDIRECT_HEADERS, "all").Get(server.URL); err == nil {
+		t.Error("invalid redirect headers should fail")
+	}
+}
+
+func TestRedirectAuth(t *testing.T) {
+	var authorization string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer target.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, 302)
+	}))
+	defer server.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_USERPWD: "user:pass",
+	})
+
+	if _, err := c.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		t.Error("credentials should not be sent to other hosts:", authorization)
+	}
+
+	if _, err := c.WithOption(OPT_UNRESTRICTED_AUTH, true).Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authorization, "Basic ") {
+		t.Error("credentials should be sent with OPT_UNRESTRICTED_AUTH:", authorization)
+	}
+}
+
+func TestRedirectDowngrade(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, 302)
+	}))
+	defer server.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_UNSAFE_TLS: true,
+	})
+
+	_, err := c.Get(server.URL)
+	if !IsRedirectError(err) || !strings.Contains(err.Error(), "https to http") {
+		t.Error("downgrade should be refused:", err)
+	}
+
+	res, err := c.WithOption(OPT_REDIRECT_DOWNGRADE, true).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := res.ToString(); body != "ok" {
+		t.Error("wrong body:", body)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"http://example.com/a", "http://EXAMPLE.com:80/b", true},
+		{"https://example.com", "https://example.com:443", true},
+		{"http://example.com", "https://example.com", false},
+		{"http://example.com", "http://example.com:8080", false},
+		{"http://example.com", "http://www.example.com", false},
+	}
+
+	for _, c := range cases {
+		a, _ := url.Parse(c.a)
+		b, _ := url.Parse(c.b)
+		if sameOrigin(a, b) != c.expected {
+			t.Error("wrong origin check:", c.a, c.b)
+		}
+	}
+}
+
+func TestRedirectHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			w.Header().Set("X-Hop", "a")
+			http.Redirect(w, r, "/b", 301)
+		case "/b":
+			http.Redirect(w, r, "/c?x=1", 302)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	res, err := NewHttpClient().Get(server.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.NumRedirects() != 2 {
+		t.Fatal("wrong number of redirects:", res.NumRedirects())
+	}
+
+	first := res.Redirects[0]
+	if first.Method != "GET" || first.URL != server.URL+"/a" || first.StatusCode != 301 ||
+		first.Location != "/b" || first.Header.Get("X-Hop") != "a" {
+		t.Error("wrong redirect:", first)
+	}
+
+	second := res.Redirects[1]
+	if second.URL != server.URL+"/b" || second.StatusCode != 302 || second.Location != "/c?x=1" {
+		t.Error("wrong redirect:", second)
+	}
+
+	if res.EffectiveURL() != server.URL+"/c?x=1" {
+		t.Error("wrong effective url:", res.EffectiveURL())
+	}
+
+	// without redirects
+	res, err = NewHttpClient().Get(server.URL + "/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NumRedirects() != 0 || res.EffectiveURL() != server.URL+"/c" {
+		t.Error("wrong redirects:", res.Redirects, res.EffectiveURL())
+	}
+
+	// redirects which are not followed are not recorded
+	res, err = NewHttpClient().WithOption(OPT_MAXREDIRS, 2).Get(server.URL + "/a")
+	if err == nil {
+		t.Fatal("redirects should be stopped")
+	}
+	if res.NumRedirects() != 1 {
+		t.Error("wrong number of redirects:", res.NumRedirects())
+	}
+}
+
+func TestPostRedirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/302":
+			http.Redirect(w, r, "/echo", 302)
+		case "/303":
+			http.Redirect(w, r, "/echo", 303)
+		default:
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write([]byte(r.Method + " " + r.Header.Get("Content-Type") + " " + string(body)))
+		}
+	}))
+	defer server.Close()
+
+	res, err := NewHttpClient().Post(server.URL+"/302", map[string]string{"a": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := res.ToString(); body != "GET  " {
+		t.Error("POST should be changed to GET:", body)
+	}
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_POSTREDIR: REDIR_POST_301 | REDIR_POST_302,
+	})
+
+	res, err = c.Post(server.URL+"/302", map[string]string{"a": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := res.ToString(); body != "POST application/x-www-form-urlencoded a=1" {
+		t.Error("POST should be kept:", body)
+	}
+
+	res, err = c.Post(server.URL+"/303", map[string]string{"a": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := res.ToString(); body != "GET  " {
+		t.Error("POST should be changed to GET on 303:", body)
+	}
+
+	// the body can not be read again
+	_, err = c.Do("POST", server.URL+"/302", nil, ioutil.NopCloser(strings.NewReader("a=1")))
+	if !IsRedirectError(err) {
+		t.Error("streaming body should not be replayed:", err)
+	}
+}
+
+func TestAutoReferer(t *testing.T) {
+	var referer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/from" {
+			http.Redirect(w, r, "/to", 302)
+			return
+		}
+		referer = r.Header.Get("Referer")
+	}))
+	defer server.Close()
+
+	if _, err := NewHttpClient().Get(server.URL + "/from?a=1"); err != nil {
+		t.Fatal(err)
+	}
+	if referer != server.URL+"/from?a=1" {
+		t.Error("wrong referer:", referer)
+	}
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_AUTOREFERER: false,
+	})
+
+	if _, err := c.Get(server.URL + "/from"); err != nil {
+		t.Fatal(err)
+	}
+	if referer != "" {
+		t.Error("referer should not be set:", referer)
+	}
+
+	if _, err := c.WithOption(OPT_REFERER, "http://example.com/").Get(server.URL + "/from"); err != nil {
+		t.Fatal(err)
+	}
+	if referer != "http://example.com/" {
+		t.Error("referer should be kept:", referer)
+	}
+}
@@ -0,0 +1,584 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signs requests of OPT_SIGNER. It's called for every request sent(including
+// redirects and retries), after all headers are set.
+type RequestSigner interface {
+	SignRequest(req *http.Request) error
+}
+
+// Adapter to use a function as RequestSigner.
+type RequestSignerFunc func(req *http.Request) error
+
+func (this RequestSignerFunc) SignRequest(req *http.Request) error {
+	return this(req)
+}
+
+// Prepare the signer of a request.
+func prepareSigner(options map[int]interface{}) (RequestSigner, error) {
+	if signer_, ok := options[OPT_SIGNER]; ok && signer_ != nil {
+		if signer, ok := signer_.(RequestSigner); ok {
+			return signer, nil
+		}
+
+		if f, ok := signer_.(func(*http.Request) error); ok {
+			return RequestSignerFunc(f), nil
+		}
+
+		return nil, fmt.Errorf("OPT_SIGNER must be a RequestSigner")
+	}
+
+	return nil, nil
+}
+
+// Transport which signs every request(including redirects to the same host).
+type signerTransport struct {
+	transport http.RoundTripper
+	signer    RequestSigner
+
+	// Requests are only signed for this host, unless unrestricted is
+	// set(OPT_UNRESTRICTED_AUTH).
+	host         string
+	unrestricted bool
+}
+
+func (this *signerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != this.host && !this.unrestricted {
+		return this.transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if err := this.signer.SignRequest(req); err != nil {
+		return nil, err
+	}
+	getRequestState(req).credentials = true
+
+	return this.transport.RoundTrip(req)
+}
+
+// Read the request body without consuming it. The body of a server side
+// request is replaced with a buffered copy.
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		return ioutil.ReadAll(body)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// Copy of the header values.
+func headerValues(header http.Header, name string) []string {
+	values := header[http.CanonicalHeaderKey(name)]
+	return append([]string(nil), values...)
+}
+
+// Host of the request, works for both client and server side requests.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+
+	return req.URL.Host
+}
+
+// Scheme of the request, works for both client and server side requests.
+func requestScheme(req *http.Request) string {
+	if req.URL.Scheme != "" {
+		return req.URL.Scheme
+	}
+
+	if req.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// Signs requests with HMAC over a canonical string built from a template.
+//
+// Placeholders of the template:
+//
+//	{method}       request method
+//	{host}         host(with port if any)
+//	{path}         escaped path
+//	{query}        raw query
+//	{uri}          path with query
+//	{timestamp}    unix timestamp in seconds
+//	{body_sha256}  hex encoded SHA-256 digest of the body
+//	{header:Name}  value of a header
+type HMACSigner struct {
+	KeyID string
+	Key   []byte
+
+	// Hash function, default to sha256.New.
+	Hash func() hash.Hash
+
+	// Template of the canonical string, default to
+	// "{method}\n{host}\n{path}\n{query}\n{timestamp}\n{body_sha256}".
+	Template string
+
+	// Header of the signature, default to "X-Signature".
+	Header string
+
+	// Value of the signature header, with placeholders {key_id}, {timestamp}
+	// and {signature}. Default to "{signature}".
+	Format string
+
+	// Header to send the timestamp with, required for verification when the
+	// template contains {timestamp}.
+	TimestampHeader string
+
+	// Encode the signature with base64 instead of hex.
+	Base64 bool
+
+	// Clock of the signer, default to time.Now.
+	Now func() time.Time
+}
+
+func (this *HMACSigner) template() string {
+	if this.Template == "" {
+		return "{method}\n{host}\n{path}\n{query}\n{timestamp}\n{body_sha256}"
+	}
+
+	return this.Template
+}
+
+func (this *HMACSigner) header() string {
+	if this.Header == "" {
+		return "X-Signature"
+	}
+
+	return this.Header
+}
+
+func (this *HMACSigner) format() string {
+	if this.Format == "" {
+		return "{signature}"
+	}
+
+	return this.Format
+}
+
+// Build the canonical string.
+func (this *HMACSigner) Canonicalize(req *http.Request, timestamp int64) (string, error) {
+	tpl := this.template()
+	var b strings.Builder
+	for {
+		i := strings.Index(tpl, "{")
+		if i < 0 {
+			b.WriteString(tpl)
+			break
+		}
+		j := strings.Index(tpl[i:], "}")
+		if j < 0 {
+			return "", fmt.Errorf("invalid signature template: %s", this.template())
+		}
+		b.WriteString(tpl[:i])
+		name := tpl[i+1 : i+j]
+		tpl = tpl[i+j+1:]
+
+		switch {
+		case name == "method":
+			b.WriteString(strings.ToUpper(req.Method))
+		case name == "host":
+			b.WriteString(requestHost(req))
+		case name == "path":
+			b.WriteString(req.URL.EscapedPath())
+		case name == "query":
+			b.WriteString(req.URL.RawQuery)
+		case name == "uri":
+			b.WriteString(req.URL.RequestURI())
+		case name == "timestamp":
+			b.WriteString(strconv.FormatInt(timestamp, 10))
+		case name == "body_sha256":
+			body, err := peekBody(req)
+			if err != nil {
+				return "", err
+			}
+			digest := sha256.Sum256(body)
+			b.WriteString(hex.EncodeToString(digest[:]))
+		case strings.HasPrefix(name, "header:"):
+			b.WriteString(strings.Join(headerValues(req.Header, name[len("header:"):]), ","))
+		default:
+			return "", fmt.Errorf("unknown placeholder in signature template: %s", name)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// Value of the signature header.
+func (this *HMACSigner) signature(req *http.Request, timestamp int64) (string, error) {
+	canonical, err := this.Canonicalize(req, timestamp)
+	if err != nil {
+		return "", err
+	}
+
+	h := this.Hash
+	if h == nil {
+		h = sha256.New
+	}
+	mac := hmac.New(h, this.Key)
+	io.WriteString(mac, canonical)
+
+	var signature string
+	if this.Base64 {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	} else {
+		signature = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return strings.NewReplacer(
+		"{key_id}", this.KeyID,
+		"{timestamp}", strconv.FormatInt(timestamp, 10),
+		"{signature}", signature,
+	).Replace(this.format()), nil
+}
+
+// Implement RequestSigner.
+func (this *HMACSigner) SignRequest(req *http.Request) error {
+	now := time.Now
+	if this.Now != nil {
+		now = this.Now
+	}
+	timestamp := now().Unix()
+
+	if this.TimestampHeader != "" {
+		req.Header.Set(this.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	}
+
+	signature, err := this.signature(req, timestamp)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(this.header(), signature)
+
+	return nil
+}
+
+// Verify a request signed by the signer(usually on the server side). The
+// timestamp must be within maxSkew when it's greater than 0.
+func (this *HMACSigner) Verify(req *http.Request, maxSkew time.Duration) error {
+	var timestamp int64
+	if this.TimestampHeader != "" {
+		var err error
+		timestamp, err = strconv.ParseInt(req.Header.Get(this.TimestampHeader), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid signature timestamp: %v", err)
+		}
+
+		if maxSkew > 0 {
+			skew := time.Since(time.Unix(timestamp, 0))
+			if skew > maxSkew || skew < -maxSkew {
+				return fmt.Errorf("signature timestamp is out of range")
+			}
+		}
+	} else if strings.Contains(this.template(), "{timestamp}") {
+		return fmt.Errorf("TimestampHeader is required to verify the timestamp")
+	}
+
+	expected, err := this.signature(req, timestamp)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(req.Header.Get(this.header()))) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
+
+// Signs requests with HTTP Message Signatures(RFC 9421) using HMAC-SHA256.
+type MessageSigner struct {
+	// Label of the signature, default to "sig1".
+	Label string
+
+	KeyID string
+	Key   []byte
+
+	// Covered components, e.g. "@method", "@authority", "@path", "@query",
+	// "@target-uri", "@scheme", "@request-target", or lower case header
+	// names. Default to "@method", "@authority", "@path" and
+	// "content-digest".
+	//
+	// The Content-Digest header(RFC 9530, SHA-256) is generated when covered
+	// but missing.
+	Components []string
+
+	// Add the "alg" parameter.
+	IncludeAlg bool
+
+	// Add the "expires" parameter when greater than 0.
+	Expires time.Duration
+
+	Nonce string
+	Tag   string
+
+	// Clock of the signer, default to time.Now.
+	Now func() time.Time
+}
+
+func (this *MessageSigner) label() string {
+	if this.Label == "" {
+		return "sig1"
+	}
+
+	return this.Label
+}
+
+func (this *MessageSigner) components() []string {
+	if len(this.Components) == 0 {
+		return []string{"@method", "@authority", "@path", "content-digest"}
+	}
+
+	return this.Components
+}
+
+// Value of a covered component.
+func messageComponent(req *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return strings.ToUpper(req.Method), nil
+	case "@authority":
+		return strings.ToLower(requestHost(req)), nil
+	case "@scheme":
+		return strings.ToLower(requestScheme(req)), nil
+	case "@target-uri":
+		return requestScheme(req) + "://" + requestHost(req) + req.URL.RequestURI(), nil
+	case "@request-target":
+		return req.URL.RequestURI(), nil
+	case "@path":
+		if path := req.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	}
+
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("unsupported component: %s", name)
+	}
+
+	values := headerValues(req.Header, name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("covered header is missing: %s", name)
+	}
+
+	for i, v := range values {
+		values[i] = strings.TrimSpace(v)
+	}
+
+	return strings.Join(values, ", "), nil
+}
+
+// Build the signature base.
+func messageSignatureBase(req *http.Request, components []string, params string) (string, error) {
+	var b strings.Builder
+	for _, name := range components {
+		value, err := messageComponent(req, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%q: %s\n", name, value)
+	}
+	fmt.Fprintf(&b, "%q: %s", "@signature-params", params)
+
+	return b.String(), nil
+}
+
+func contentDigest(body []byte) string {
+	digest := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+}
+
+// Implement RequestSigner, Signature-Input and Signature headers are set.
+func (this *MessageSigner) SignRequest(req *http.Request) error {
+	components := this.components()
+	for _, name := range components {
+		if name == "content-digest" && req.Header.Get("Content-Digest") == "" {
+			body, err := peekBody(req)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Digest", contentDigest(body))
+		}
+	}
+
+	now := time.Now
+	if this.Now != nil {
+		now = this.Now
+	}
+	created := now().Unix()
+
+	quoted := make([]string, len(components))
+	for i, name := range components {
+		quoted[i] = strconv.Quote(name)
+	}
+	params := "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(created, 10)
+	if this.Expires > 0 {
+		params += ";expires=" + strconv.FormatInt(created+int64(this.Expires/time.Second), 10)
+	}
+	if this.Nonce != "" {
+		params += ";nonce=" + strconv.Quote(this.Nonce)
+	}
+	if this.IncludeAlg {
+		params += `;alg="hmac-sha256"`
+	}
+	if this.KeyID != "" {
+		params += ";keyid=" + strconv.Quote(this.KeyID)
+	}
+	if this.Tag != "" {
+		params += ";tag=" + strconv.Quote(this.Tag)
+	}
+
+	base, err := messageSignatureBase(req, components, params)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, this.Key)
+	io.WriteString(mac, base)
+
+	req.Header.Set("Signature-Input", this.label()+"="+params)
+	req.Header.Set("Signature", this.label()+"=:"+base64.StdEncoding.EncodeToString(mac.Sum(nil))+":")
+
+	return nil
+}
+
+// Find the member of a dictionary header(e.g. `sig1=...`), members are
+// separated by commas outside of quotes and parentheses.
+func dictionaryMember(header string, label string) (string, bool) {
+	var members []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(header); i++ {
+		switch c := header[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted:
+			depth--
+		case c == ',' && !quoted && depth == 0:
+			members = append(members, header[start:i])
+			start = i + 1
+		}
+	}
+	members = append(members, header[start:])
+
+	for _, m := range members {
+		m = strings.TrimSpace(m)
+		if strings.HasPrefix(m, label+"=") {
+			return m[len(label)+1:], true
+		}
+	}
+
+	return "", false
+}
+
+// Verify a request signed with the signer's label and key(usually on the
+// server side). The Content-Digest header is checked against the body when
+// covered.
+func (this *MessageSigner) Verify(req *http.Request) error {
+	params, ok := dictionaryMember(strings.Join(headerValues(req.Header, "Signature-Input"), ", "), this.label())
+	if !ok {
+		return fmt.Errorf("signature input is missing: %s", this.label())
+	}
+
+	signature, ok := dictionaryMember(strings.Join(headerValues(req.Header, "Signature"), ", "), this.label())
+	if !ok || len(signature) < 2 || signature[0] != ':' || signature[len(signature)-1] != ':' {
+		return fmt.Errorf("signature is missing: %s", this.label())
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(signature[1 : len(signature)-1])
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	end := strings.Index(params, ")")
+	if !strings.HasPrefix(params, "(") || end < 0 {
+		return fmt.Errorf("invalid signature input: %s", params)
+	}
+
+	var components []string
+	for _, v := range strings.Fields(params[1:end]) {
+		name, err := strconv.Unquote(v)
+		if err != nil {
+			return fmt.Errorf("invalid signature input: %s", params)
+		}
+		components = append(components, name)
+	}
+
+	for _, p := range strings.Split(params[end+1:], ";") {
+		if strings.HasPrefix(p, "expires=") {
+			expires, err := strconv.ParseInt(p[len("expires="):], 10, 64)
+			if err != nil || time.Now().Unix() > expires {
+				return fmt.Errorf("signature expired")
+			}
+		}
+	}
+
+	base, err := messageSignatureBase(req, components, params)
+	if err != nil {
+		return err
+	}
+
+	expected := hmac.New(sha256.New, this.Key)
+	io.WriteString(expected, base)
+	if !hmac.Equal(expected.Sum(nil), mac) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	for _, name := range components {
+		if name == "content-digest" && strings.HasPrefix(req.Header.Get("Content-Digest"), "sha-256=") {
+			body, err := peekBody(req)
+			if err != nil {
+				return err
+			}
+
+			if !strings.Contains(req.Header.Get("Content-Digest"), contentDigest(body)) {
+				return fmt.Errorf("content digest mismatch")
+			}
+		}
+	}
+
+	return nil
+}
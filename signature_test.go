@@ -0,0 +1,217 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Signature base of example B.2.5 of RFC 9421.
+func TestMessageSignatureBase(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+
+	signer := &MessageSigner{
+		Label:      "sig-b25",
+		KeyID:      "test-shared-secret",
+		Key:        []byte("secret"),
+		Components: []string{"date", "@authority", "content-type"},
+		Now: func() time.Time {
+			return time.Unix(1618884473, 0)
+		},
+	}
+
+	if err := signer.SignRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	params := `("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`
+	if v := req.Header.Get("Signature-Input"); v != "sig-b25="+params {
+		t.Error("wrong signature input:", v)
+	}
+
+	base, err := messageSignatureBase(req, signer.Components, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ` + params
+	if base != expected {
+		t.Error("wrong signature base:", base)
+	}
+
+	mac := hmac.New(sha256.New, signer.Key)
+	io.WriteString(mac, base)
+	if v := req.Header.Get("Signature"); v != "sig-b25=:"+base64.StdEncoding.EncodeToString(mac.Sum(nil))+":" {
+		t.Error("wrong signature:", v)
+	}
+}
+
+func TestMessageSigner(t *testing.T) {
+	signer := &MessageSigner{
+		KeyID:   "key",
+		Key:     []byte("secret"),
+		Expires: time.Minute,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := signer.Verify(r); err != nil {
+			w.WriteHeader(401)
+			io.WriteString(w, err.Error())
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer server.Close()
+
+	res, err := NewHttpClient().
+		WithOption(OPT_SIGNER, signer).
+		WithHeader("X-Custom", "value").
+		PostJson(server.URL+"/path?a=b", map[string]string{"hello": "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); res.StatusCode != 200 || body != `{"hello":"world"}` {
+		t.Error("message signature does not verify:", res.StatusCode, body)
+	}
+
+	// tampered
+	res, err = NewHttpClient().
+		WithOption(OPT_SIGNER, RequestSignerFunc(func(req *http.Request) error {
+			if err := signer.SignRequest(req); err != nil {
+				return err
+			}
+			req.URL.Path = "/other"
+			return nil
+		})).
+		Get(server.URL + "/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 401 {
+		t.Error("tampered request should not verify")
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	signer := &HMACSigner{
+		KeyID:           "partner",
+		Key:             []byte("secret"),
+		Template:        "{method}\n{uri}\n{header:X-Custom}\n{timestamp}\n{body_sha256}",
+		Header:          "Authorization",
+		Format:          "HMAC-SHA256 {key_id}:{signature}",
+		TimestampHeader: "X-Timestamp",
+		Base64:          true,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := signer.Verify(r, time.Minute); err != nil {
+			w.WriteHeader(401)
+			io.WriteString(w, err.Error())
+			return
+		}
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_SIGNER: signer,
+		"X-Custom": "value",
+	})
+
+	res, err := c.Post(server.URL+"/path?a=b", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); res.StatusCode != 200 || !strings.HasPrefix(body, "HMAC-SHA256 partner:") {
+		t.Error("hmac signature does not verify:", res.StatusCode, body)
+	}
+
+	// header is signed after all headers are merged
+	res, err = c.WithHeader("X-Custom", "changed").Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 200 {
+		t.Error("one time headers should be signed:", res.StatusCode)
+	}
+
+	// expired
+	signer.Now = func() time.Time {
+		return time.Now().Add(-time.Hour)
+	}
+	res, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 401 {
+		t.Error("expired signature should not verify")
+	}
+}
+
+func TestHMACSignerDefaultTemplate(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com:8080/path?a=b", nil)
+	canonical, err := (&HMACSigner{}).Canonicalize(req, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sha256 of the empty body
+	expected := "GET\nexample.com:8080\n/path\na=b\n1\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if canonical != expected {
+		t.Errorf("wrong canonical string: %q", canonical)
+	}
+}
+
+func TestSignerRedirect(t *testing.T) {
+	var signature string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+	}))
+	defer target.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, 302)
+	}))
+	defer server.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_SIGNER: &HMACSigner{
+			KeyID: "partner",
+			Key:   []byte("secret"),
+		},
+	})
+
+	if _, err := c.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if signature != "" {
+		t.Error("requests to other hosts should not be signed:", signature)
+	}
+
+	if _, err := c.WithOption(OPT_UNRESTRICTED_AUTH, true).Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if signature == "" {
+		t.Error("requests should be signed with OPT_UNRESTRICTED_AUTH")
+	}
+}
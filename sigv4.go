@@ -0,0 +1,354 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Payload hash of requests with streaming bodies.
+const UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+
+// AWS credentials, the session token is optional.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Implement AWSCredentialsProvider, so static credentials can be used
+// directly.
+func (this AWSCredentials) Credentials() (AWSCredentials, error) {
+	return this, nil
+}
+
+// Provides AWS credentials for every request, implement it to rotate
+// credentials.
+type AWSCredentialsProvider interface {
+	Credentials() (AWSCredentials, error)
+}
+
+// Adapter to use a function as AWSCredentialsProvider.
+type AWSCredentialsFunc func() (AWSCredentials, error)
+
+func (this AWSCredentialsFunc) Credentials() (AWSCredentials, error) {
+	return this()
+}
+
+// Signer of AWS signature version 4.
+type awsSigner struct {
+	// Used in the algorithm and key names, e.g. "aws".
+	provider1 string
+
+	// Used in header names, e.g. "amz".
+	provider2 string
+
+	region  string
+	service string
+
+	credentials AWSCredentialsProvider
+
+	// Do not sign the payload.
+	unsignedPayload bool
+
+	now func() time.Time
+}
+
+// Prepare the signer with OPT_AWS_SIGV4, the option is a string in the form
+// of "provider1[:provider2[:region[:service]]]" like curl, region and service
+// are parsed from the host when omitted.
+//
+// Credentials come from OPT_AWS_CREDENTIALS, or OPT_USERPWD in the form of
+// "access_key:secret_key".
+func prepareAWSSigner(u *url.URL, options map[int]interface{}) (*awsSigner, error) {
+	sigv4_, ok := options[OPT_AWS_SIGV4]
+	if !ok {
+		return nil, nil
+	}
+
+	sigv4, ok := sigv4_.(string)
+	if !ok {
+		return nil, fmt.Errorf("OPT_AWS_SIGV4 must be string")
+	}
+
+	signer := &awsSigner{
+		provider1: "aws",
+		provider2: "amz",
+		now:       time.Now,
+	}
+
+	parts := strings.Split(sigv4, ":")
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("invalid OPT_AWS_SIGV4: %s", sigv4)
+	}
+	if parts[0] != "" {
+		signer.provider1 = strings.ToLower(parts[0])
+		signer.provider2 = signer.provider1
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		signer.provider2 = strings.ToLower(parts[1])
+	}
+	if len(parts) > 2 {
+		signer.region = parts[2]
+	}
+	if len(parts) > 3 {
+		signer.service = parts[3]
+	}
+
+	// service.region.amazonaws.com
+	if signer.region == "" || signer.service == "" {
+		labels := strings.Split(u.Hostname(), ".")
+		if len(labels) < 4 {
+			return nil, fmt.Errorf("cannot parse region and service from host: %s", u.Host)
+		}
+		if signer.service == "" {
+			signer.service = labels[0]
+		}
+		if signer.region == "" {
+			signer.region = labels[1]
+		}
+	}
+
+	if credentials_, ok := options[OPT_AWS_CREDENTIALS]; ok {
+		if signer.credentials, ok = credentials_.(AWSCredentialsProvider); !ok {
+			return nil, fmt.Errorf("OPT_AWS_CREDENTIALS must be AWSCredentialsProvider")
+		}
+	} else if userpwd_, ok := options[OPT_USERPWD]; ok {
+		userpwd, _ := userpwd_.(string)
+		i := strings.Index(userpwd, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("OPT_USERPWD must be \"access_key:secret_key\" for OPT_AWS_SIGV4")
+		}
+		signer.credentials = AWSCredentials{
+			AccessKeyID:     userpwd[:i],
+			SecretAccessKey: userpwd[i+1:],
+		}
+	} else {
+		return nil, fmt.Errorf("credentials are required for OPT_AWS_SIGV4")
+	}
+
+	if unsigned_, ok := options[OPT_AWS_UNSIGNED_PAYLOAD]; ok {
+		if signer.unsignedPayload, ok = unsigned_.(bool); !ok {
+			return nil, fmt.Errorf("OPT_AWS_UNSIGNED_PAYLOAD must be bool")
+		}
+	}
+
+	return signer, nil
+}
+
+// Hash of the request body, the body is read from GetBody so that it can still
+// be sent.
+func (this *awsSigner) payloadHash(req *http.Request) (string, error) {
+	if this.unsignedPayload {
+		return UNSIGNED_PAYLOAD, nil
+	}
+
+	h := sha256.New()
+	if req.Body != nil && req.Body != http.NoBody {
+		// streaming body
+		if req.GetBody == nil {
+			return UNSIGNED_PAYLOAD, nil
+		}
+
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign the request in place.
+func (this *awsSigner) sign(req *http.Request) error {
+	credentials, err := this.credentials.Credentials()
+	if err != nil {
+		return err
+	}
+
+	payloadHash, err := this.payloadHash(req)
+	if err != nil {
+		return err
+	}
+
+	now := this.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	headerPrefix := "X-" + strings.ToUpper(this.provider2[:1]) + this.provider2[1:] + "-"
+
+	req.Header.Del("Authorization")
+	req.Header.Set(headerPrefix+"Date", amzDate)
+	if this.service == "s3" || payloadHash == UNSIGNED_PAYLOAD {
+		req.Header.Set(headerPrefix+"Content-Sha256", payloadHash)
+	}
+	if credentials.SessionToken != "" {
+		req.Header.Set(headerPrefix+"Security-Token", credentials.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// headers to sign
+	headers := map[string]string{
+		"host": host,
+	}
+	lowerPrefix := strings.ToLower(headerPrefix)
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, lowerPrefix) || lk == "content-type" || lk == "content-md5" {
+			values := make([]string, len(v))
+			for i, vv := range v {
+				values[i] = strings.Join(strings.Fields(vv), " ")
+			}
+			headers[lk] = strings.Join(values, ",")
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := awsEscapePath(req.URL.Path)
+	if this.service != "s3" {
+		path = awsEscapePath(path)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		awsCanonicalQuery(req.URL.RawQuery),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	algorithm := strings.ToUpper(this.provider1) + "4-HMAC-SHA256"
+	scope := strings.Join([]string{date, this.region, this.service, this.provider1 + "4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := []byte(strings.ToUpper(this.provider1) + "4" + credentials.SecretAccessKey)
+	for _, v := range []string{date, this.region, this.service, this.provider1 + "4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, credentials.AccessKeyID, scope, signedHeaders, signature))
+
+	return nil
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
+
+// Encode with the rules of AWS, only unreserved characters are kept.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func awsEscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	return awsEscape(path, true)
+}
+
+// Query sorted by key and value.
+func awsCanonicalQuery(rawQuery string) string {
+	values, _ := url.ParseQuery(rawQuery)
+	var pairs [][2]string
+	for k, v := range values {
+		for _, vv := range v {
+			pairs = append(pairs, [2]string{awsEscape(k, false), awsEscape(vv, false)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	query := make([]string, len(pairs))
+	for i, p := range pairs {
+		query[i] = p[0] + "=" + p[1]
+	}
+
+	return strings.Join(query, "&")
+}
+
+// Transport which signs every request(including redirects to the same host)
+// with AWS signature version 4.
+type awsSigV4Transport struct {
+	transport http.RoundTripper
+	signer    *awsSigner
+
+	// Requests are only signed for this host, unless unrestricted is
+	// set(OPT_UNRESTRICTED_AUTH).
+	host         string
+	unrestricted bool
+}
+
+func (this *awsSigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != this.host && !this.unrestricted {
+		return this.transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if err := this.signer.sign(req); err != nil {
+		return nil, err
+	}
+	getRequestState(req).credentials = true
+
+	return this.transport.RoundTrip(req)
+}
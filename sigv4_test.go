@@ -0,0 +1,147 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Cases from the AWS signature version 4 test suite.
+func TestAWSSigV4Suite(t *testing.T) {
+	cases := map[string]string{
+		"https://example.amazonaws.com/":                             "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		"https://example.amazonaws.com/?Param2=value2&Param1=value1": "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+	}
+
+	for u, signature := range cases {
+		req, _ := http.NewRequest("GET", u, nil)
+		signer, err := prepareAWSSigner(req.URL, map[int]interface{}{
+			OPT_AWS_SIGV4: "aws:amz:us-east-1:service",
+			OPT_USERPWD:   "AKIDEXAMPLE:wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer.now = func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		}
+
+		if err := signer.sign(req); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=host;x-amz-date, Signature=" + signature
+		if authorization := req.Header.Get("Authorization"); authorization != expected {
+			t.Error("wrong signature:", u, authorization)
+		}
+	}
+}
+
+func TestAWSSigV4(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash != UNSIGNED_PAYLOAD {
+			h := sha256.Sum256(body)
+			if payloadHash != hex.EncodeToString(h[:]) {
+				w.WriteHeader(400)
+				return
+			}
+		}
+
+		io.WriteString(w, payloadHash+"\n"+r.Header.Get("X-Amz-Security-Token")+"\n"+r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_AWS_SIGV4: "aws:amz:us-east-1:s3",
+		OPT_USERPWD:   "key:secret",
+	})
+
+	res, err := c.PutJson(server.URL+"/bucket/key", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := res.ToString()
+	lines := strings.Split(body, "\n")
+	if res.StatusCode != 200 || lines[0] == UNSIGNED_PAYLOAD ||
+		!strings.HasPrefix(lines[2], "AWS4-HMAC-SHA256 Credential=key/") ||
+		!strings.Contains(lines[2], "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date,") {
+		t.Error("payload is not signed:", res.StatusCode, body)
+	}
+
+	// streaming
+	res, err = c.Put(server.URL+"/bucket/key", ioutil.NopCloser(strings.NewReader("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body, _ := res.ToString(); res.StatusCode != 200 || !strings.HasPrefix(body, UNSIGNED_PAYLOAD) {
+		t.Error("streaming payload should not be signed:", res.StatusCode, body)
+	}
+
+	// rotating credentials
+	calls := 0
+	res, err = c.
+		WithOption(OPT_AWS_CREDENTIALS, AWSCredentialsFunc(func() (AWSCredentials, error) {
+			calls++
+			return AWSCredentials{
+				AccessKeyID:     "rotated",
+				SecretAccessKey: "secret",
+				SessionToken:    "session",
+			}, nil
+		})).
+		Get(server.URL + "/bucket/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ = res.ToString()
+	lines = strings.Split(body, "\n")
+	if calls != 1 || lines[1] != "session" || !strings.Contains(lines[2], "Credential=rotated/") {
+		t.Error("OPT_AWS_CREDENTIALS does not work:", body)
+	}
+}
+
+func TestAWSSigV4Redirect(t *testing.T) {
+	var authorization string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer target.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, 302)
+	}))
+	defer server.Close()
+
+	c := NewHttpClient().Defaults(Map{
+		OPT_AWS_SIGV4: "aws:amz:us-east-1:s3",
+		OPT_USERPWD:   "key:secret",
+	})
+
+	if _, err := c.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		t.Error("requests to other hosts should not be signed:", authorization)
+	}
+
+	if _, err := c.WithOption(OPT_UNRESTRICTED_AUTH, true).Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		t.Error("requests should be signed with OPT_UNRESTRICTED_AUTH:", authorization)
+	}
+}
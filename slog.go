@@ -0,0 +1,89 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+//go:build go1.21
+// +build go1.21
+
+package httpclient
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A Logger of log/slog.
+type slogLogger struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// Create a logger with log/slog, requests and responses are logged at the
+// level, and responses with errors at slog.LevelError.
+func NewSlogLogger(logger *slog.Logger, level slog.Level) Logger {
+	return &slogLogger{
+		logger: logger,
+		level:  level,
+	}
+}
+
+func (this *slogLogger) LogRequest(req *RequestLog) {
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", req.URL),
+		slogHeader(req.Header),
+	}
+	attrs = appendSlogBody(attrs, req.Body, req.BodyTruncated)
+
+	this.logger.LogAttrs(context.Background(), this.level, "http request", attrs...)
+}
+
+func (this *slogLogger) LogResponse(res *ResponseLog) {
+	attrs := []slog.Attr{
+		slog.String("method", res.Method),
+		slog.String("url", res.URL),
+		slog.Duration("duration", res.Duration),
+	}
+
+	level := this.level
+	if res.StatusCode != 0 {
+		attrs = append(attrs, slog.Int("status", res.StatusCode), slogHeader(res.Header))
+		attrs = appendSlogBody(attrs, res.Body, res.BodyTruncated)
+	}
+	if res.Err != nil {
+		attrs = append(attrs, slog.String("error", res.Err.Error()))
+		level = slog.LevelError
+	}
+
+	this.logger.LogAttrs(context.Background(), level, "http response", attrs...)
+}
+
+func slogHeader(header http.Header) slog.Attr {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.String(name, strings.Join(header[name], ", ")))
+	}
+
+	return slog.Group("header", attrs...)
+}
+
+func appendSlogBody(attrs []slog.Attr, body []byte, truncated bool) []slog.Attr {
+	if body == nil {
+		return attrs
+	}
+
+	attrs = append(attrs, slog.String("body", string(body)))
+	if truncated {
+		attrs = append(attrs, slog.Bool("body_truncated", true))
+	}
+
+	return attrs
+}
@@ -0,0 +1,53 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+//go:build go1.21
+// +build go1.21
+
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(buf, nil)), slog.LevelInfo)
+
+	res, err := NewHttpClient().
+		WithOption(OPT_LOGGER, logger).
+		WithHeader("Authorization", "Bearer secret").
+		Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatal("wrong logs:", buf.String())
+	}
+
+	var req, res_ map[string]interface{}
+	json.Unmarshal(lines[0], &req)
+	json.Unmarshal(lines[1], &res_)
+
+	header, _ := req["header"].(map[string]interface{})
+	if req["msg"] != "http request" || req["method"] != "GET" || header["Authorization"] != "[REDACTED]" {
+		t.Error("wrong request log:", string(lines[0]))
+	}
+
+	if res_["msg"] != "http response" || res_["status"] != float64(200) || res_["body"] != "ok" {
+		t.Error("wrong response log:", string(lines[1]))
+	}
+}
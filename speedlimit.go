@@ -0,0 +1,174 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Bytes can be transferred at once, in time of the rate.
+const speedLimitBurst = 100 * time.Millisecond
+
+// A bandwidth limiter of bytes per second, see OPT_MAX_SEND_SPEED and
+// OPT_MAX_RECV_SPEED. Share it between requests(e.g. with Defaults) to limit
+// them together.
+type SpeedLimiter struct {
+	lock sync.Mutex
+
+	// Bytes per second.
+	rate  float64
+	burst int
+
+	// Negative tokens are reserved by waiting transfers.
+	tokens float64
+	last   time.Time
+}
+
+// Create a speed limiter allows bytesPerSecond bytes per second.
+func NewSpeedLimiter(bytesPerSecond int64) *SpeedLimiter {
+	burst := int(float64(bytesPerSecond) * speedLimitBurst.Seconds())
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &SpeedLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Take n bytes, returns how long to wait for them.
+func (this *SpeedLimiter) reserve(n int, now time.Time) time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if now.After(this.last) {
+		this.tokens += now.Sub(this.last).Seconds() * this.rate
+		this.last = now
+	}
+
+	if this.tokens > float64(this.burst) {
+		this.tokens = float64(this.burst)
+	}
+
+	this.tokens -= float64(n)
+	if this.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-this.tokens / this.rate * float64(time.Second))
+}
+
+// Wait until n bytes are allowed or the context is done.
+func (this *SpeedLimiter) wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	wait := this.reserve(n, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A body limited by a speed limiter.
+type speedLimitReader struct {
+	io.ReadCloser
+	limiter *SpeedLimiter
+	ctx     context.Context
+}
+
+func (this *speedLimitReader) Read(p []byte) (int, error) {
+	// small reads so that the transfer is smooth
+	if len(p) > this.limiter.burst {
+		p = p[:this.limiter.burst]
+	}
+
+	n, err := this.ReadCloser.Read(p)
+	if werr := this.limiter.wait(this.ctx, n); werr != nil {
+		return n, werr
+	}
+
+	return n, err
+}
+
+// A RoundTripper limits the speed of request and response bodies.
+type speedLimitTransport struct {
+	transport http.RoundTripper
+	send      *SpeedLimiter
+	recv      *SpeedLimiter
+}
+
+// Implement http.RoundTripper.
+func (this *speedLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if this.send != nil && req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(ctx)
+		req.Body = &speedLimitReader{req.Body, this.send, ctx}
+	}
+
+	res, err := this.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if this.recv != nil {
+		res.Body = &speedLimitReader{res.Body, this.recv, ctx}
+	}
+
+	return res, nil
+}
+
+// Prepare speed limiters with OPT_MAX_SEND_SPEED and OPT_MAX_RECV_SPEED.
+func prepareSpeedLimiters(options map[int]interface{}) (send *SpeedLimiter, recv *SpeedLimiter, err error) {
+	if send, err = toSpeedLimiter(options[OPT_MAX_SEND_SPEED]); err != nil {
+		return nil, nil, fmt.Errorf("OPT_MAX_SEND_SPEED %s", err)
+	}
+
+	if recv, err = toSpeedLimiter(options[OPT_MAX_RECV_SPEED]); err != nil {
+		return nil, nil, fmt.Errorf("OPT_MAX_RECV_SPEED %s", err)
+	}
+
+	return send, recv, nil
+}
+
+// A number creates a limiter of the request, zero means unlimited.
+func toSpeedLimiter(speed_ interface{}) (*SpeedLimiter, error) {
+	var speed int64
+	switch s := speed_.(type) {
+	case nil:
+		return nil, nil
+	case *SpeedLimiter:
+		return s, nil
+	case int:
+		speed = int64(s)
+	case int64:
+		speed = s
+	default:
+		return nil, fmt.Errorf("must be bytes per second or *httpclient.SpeedLimiter")
+	}
+
+	if speed <= 0 {
+		return nil, nil
+	}
+
+	return NewSpeedLimiter(speed), nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpeedLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		w.Write(bytes.Repeat([]byte("a"), size))
+	}))
+	defer server.Close()
+
+	// 50000 bytes at 100000 bytes/s, minus the burst
+	start := time.Now()
+	res, err := NewHttpClient().
+		WithOption(OPT_MAX_RECV_SPEED, 100000).
+		Get(server.URL + "?size=50000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := res.ReadAll()
+	if err != nil || len(body) != 50000 {
+		t.Fatal("wrong body:", len(body), err)
+	}
+	if d := time.Since(start); d < 350*time.Millisecond {
+		t.Error("download is not limited:", d)
+	}
+
+	start = time.Now()
+	res, err = NewHttpClient().
+		WithOption(OPT_MAX_SEND_SPEED, int64(100000)).
+		Put(server.URL, bytes.NewReader(make([]byte, 50000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+	if d := time.Since(start); d < 350*time.Millisecond {
+		t.Error("upload is not limited:", d)
+	}
+
+	// shared by requests of the client
+	c := NewHttpClient().Defaults(Map{
+		OPT_MAX_RECV_SPEED: NewSpeedLimiter(100000),
+	})
+
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Begin().Get(server.URL + "?size=25000")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.ReadAll()
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 350*time.Millisecond {
+		t.Error("downloads are not limited together:", d)
+	}
+
+	// unlimited
+	start = time.Now()
+	res, err = NewHttpClient().
+		WithOption(OPT_MAX_RECV_SPEED, 0).
+		Get(server.URL + "?size=50000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Error("download should not be limited:", d)
+	}
+
+	if _, err := NewHttpClient().WithOption(OPT_MAX_SEND_SPEED, "1k").Get(server.URL); err == nil {
+		t.Error("invalid speed should fail")
+	}
+}
+
+func TestSpeedLimitCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 100000))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	res, err := NewHttpClient().
+		WithOption(OPT_MAX_RECV_SPEED, 1000).
+		WithOption(OPT_CONTEXT, ctx).
+		Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = res.ReadAll()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("download should be canceled:", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Error("cancel is too slow:", d)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings of a request(like curl's -w %{time_*}). Phases are of the last
+// connection when there are redirects, durations from the start include
+// redirects.
+type Timings struct {
+	// Zero if the connection is reused.
+	DNSLookup    time.Duration
+	TCPConnect   time.Duration
+	TLSHandshake time.Duration
+
+	// From the request is written to the first byte of the response.
+	ServerProcessing time.Duration
+
+	// From the start to the first byte of the response(like
+	// %{time_starttransfer}).
+	FirstByte time.Duration
+
+	// From the first byte to the end of the body, zero until the body is
+	// read or closed.
+	ContentTransfer time.Duration
+
+	// From the start to the end of the body(like %{time_total}), zero until
+	// the body is read or closed.
+	Total time.Duration
+
+	// Whether the connection is reused(or an idle connection is used).
+	ConnReused bool
+
+	RemoteAddr string
+	LocalAddr  string
+}
+
+// Record events of a request with httptrace. Dials may report after the
+// request is done, so events are recorded with the lock and copied to
+// Timings.
+type timingRecorder struct {
+	lock sync.Mutex
+
+	start time.Time
+
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+
+	reused     bool
+	remoteAddr string
+	localAddr  string
+}
+
+func newTimingRecorder() *timingRecorder {
+	return &timingRecorder{
+		start: time.Now(),
+	}
+}
+
+func (this *timingRecorder) record(f func()) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	f()
+}
+
+func (this *timingRecorder) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			// a new round trip(of redirects or retries)
+			this.record(func() {
+				this.dnsStart = time.Time{}
+				this.dnsDone = time.Time{}
+				this.connectStart = time.Time{}
+				this.connectDone = time.Time{}
+				this.tlsStart = time.Time{}
+				this.tlsDone = time.Time{}
+				this.gotConn = time.Time{}
+				this.wroteRequest = time.Time{}
+				this.firstByte = time.Time{}
+				this.reused = false
+				this.remoteAddr = ""
+				this.localAddr = ""
+			})
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			this.record(func() { this.dnsStart = time.Now() })
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			this.record(func() { this.dnsDone = time.Now() })
+		},
+		ConnectStart: func(network, addr string) {
+			this.record(func() {
+				if this.connectStart.IsZero() {
+					this.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				this.record(func() { this.connectDone = time.Now() })
+			}
+		},
+		TLSHandshakeStart: func() {
+			this.record(func() { this.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			this.record(func() { this.tlsDone = time.Now() })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			this.record(func() {
+				this.gotConn = time.Now()
+				this.reused = info.Reused || info.WasIdle
+				if info.Conn != nil {
+					this.remoteAddr = info.Conn.RemoteAddr().String()
+					this.localAddr = info.Conn.LocalAddr().String()
+				}
+			})
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			this.record(func() { this.wroteRequest = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			this.record(func() { this.firstByte = time.Now() })
+		},
+	}
+}
+
+// Timings recorded so far.
+func (this *timingRecorder) timings() *Timings {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return &Timings{
+		DNSLookup:        between(this.dnsStart, this.dnsDone),
+		TCPConnect:       between(this.connectStart, this.connectDone),
+		TLSHandshake:     between(this.tlsStart, this.tlsDone),
+		ServerProcessing: between(this.wroteRequest, this.firstByte),
+		FirstByte:        between(this.start, this.firstByte),
+		ConnReused:       this.reused,
+		RemoteAddr:       this.remoteAddr,
+		LocalAddr:        this.localAddr,
+	}
+}
+
+// Zero if any of the time is unknown.
+func between(start time.Time, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+
+	return end.Sub(start)
+}
+
+// A response body completes the timings when it's read or closed.
+type timingBody struct {
+	io.ReadCloser
+	timings  *Timings
+	recorder *timingRecorder
+	once     sync.Once
+}
+
+func (this *timingBody) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+	if err == io.EOF {
+		this.done()
+	}
+
+	return n, err
+}
+
+func (this *timingBody) Close() error {
+	this.done()
+
+	return this.ReadCloser.Close()
+}
+
+func (this *timingBody) done() {
+	this.once.Do(func() {
+		now := time.Now()
+		this.recorder.lock.Lock()
+		firstByte := this.recorder.firstByte
+		this.recorder.lock.Unlock()
+
+		this.timings.ContentTransfer = between(firstByte, now)
+		this.timings.Total = between(this.recorder.start, now)
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("b"))
+	}))
+	defer server.Close()
+
+	c := NewHttpClient()
+	res, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timings := res.Timings
+	if timings.TCPConnect <= 0 || timings.ConnReused {
+		t.Error("connection should be new:", timings)
+	}
+	if timings.ServerProcessing < 50*time.Millisecond || timings.FirstByte < timings.ServerProcessing {
+		t.Error("wrong time to first byte:", timings)
+	}
+	if timings.RemoteAddr != server.Listener.Addr().String() || timings.LocalAddr == "" {
+		t.Error("wrong addresses:", timings)
+	}
+	if timings.Total != 0 {
+		t.Error("total should be unknown before the body is read:", timings)
+	}
+
+	if body, _ := res.ToString(); body != "ab" {
+		t.Error("wrong body:", body)
+	}
+	if timings.ContentTransfer < 50*time.Millisecond || timings.Total < timings.FirstByte+timings.ContentTransfer {
+		t.Error("wrong transfer time:", timings)
+	}
+
+	// reused
+	res, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+	if !res.Timings.ConnReused || res.Timings.TCPConnect != 0 {
+		t.Error("connection should be reused:", res.Timings)
+	}
+}
+
+func TestTimingsTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", 302)
+		}
+	}))
+	defer server.Close()
+
+	res, err := NewHttpClient().
+		WithOption(OPT_UNSAFE_TLS, true).
+		Get(server.URL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// the connection is reused by the redirect
+	if !res.Timings.ConnReused || res.Timings.TLSHandshake != 0 {
+		t.Error("connection should be reused:", res.Timings)
+	}
+
+	res, err = NewHttpClient().
+		WithOption(OPT_UNSAFE_TLS, true).
+		Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.Timings.TLSHandshake <= 0 || res.Timings.Total <= 0 {
+		t.Error("wrong timings:", res.Timings)
+	}
+}
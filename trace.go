@@ -0,0 +1,309 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bytes of a line of hex dumps.
+const traceHexWidth = 16
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSL 3.0",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// Guard writes of all tracers, concurrent requests might share a writer which
+// is not safe for concurrent use.
+var traceWriteLock sync.Mutex
+
+// Write the trace of a request(like curl --trace and --trace-ascii), see
+// OPT_TRACE.
+type tracer struct {
+	// Guard the header.
+	lock  sync.Mutex
+	w     io.Writer
+	ascii bool
+
+	// Header fields written to the wire.
+	header bytes.Buffer
+}
+
+// Write a block of the trace at once, so that traces of concurrent requests
+// do not mix within a block.
+func (this *tracer) write(buf *bytes.Buffer) {
+	traceWriteLock.Lock()
+	defer traceWriteLock.Unlock()
+
+	this.w.Write(buf.Bytes())
+}
+
+func (this *tracer) info(format string, args ...interface{}) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s == Info: %s\n", traceTime(), fmt.Sprintf(format, args...))
+
+	this.write(buf)
+}
+
+// Dump data sent(=>) or received(<=).
+func (this *tracer) dump(direction string, kind string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s %s %s, %d bytes (0x%x)\n", traceTime(), direction, kind, len(data), len(data))
+	if this.ascii {
+		dumpASCII(buf, data)
+	} else {
+		dumpHex(buf, data)
+	}
+
+	this.write(buf)
+}
+
+func traceTime() string {
+	return time.Now().Format("15:04:05.000000")
+}
+
+// Hex and ASCII, like curl --trace.
+func dumpHex(buf *bytes.Buffer, data []byte) {
+	for offset := 0; offset < len(data); offset += traceHexWidth {
+		line := data[offset:]
+		if len(line) > traceHexWidth {
+			line = line[:traceHexWidth]
+		}
+
+		fmt.Fprintf(buf, "%04x: ", offset)
+		for i := 0; i < traceHexWidth; i++ {
+			if i < len(line) {
+				fmt.Fprintf(buf, "%02x ", line[i])
+			} else {
+				buf.WriteString("   ")
+			}
+		}
+
+		for _, c := range line {
+			buf.WriteByte(printable(c))
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+// Lines of ASCII, like curl --trace-ascii.
+func dumpASCII(buf *bytes.Buffer, data []byte) {
+	offset := 0
+	for offset < len(data) {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += offset + 1
+		}
+
+		fmt.Fprintf(buf, "%04x: ", offset)
+		for _, c := range bytes.TrimRight(data[offset:end], "\r\n") {
+			buf.WriteByte(printable(c))
+		}
+		buf.WriteByte('\n')
+
+		offset = end
+	}
+}
+
+func printable(c byte) byte {
+	if c < 0x20 || c >= 0x7f {
+		return '.'
+	}
+
+	return c
+}
+
+func (this *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			this.info("Resolving %s", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				this.info("Could not resolve: %v", info.Err)
+				return
+			}
+
+			addrs := make([]string, len(info.Addrs))
+			for i, addr := range info.Addrs {
+				addrs[i] = addr.String()
+			}
+			this.info("Resolved to %s", strings.Join(addrs, ", "))
+		},
+		ConnectStart: func(network, addr string) {
+			this.info("Trying %s...", addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				this.info("Failed to connect to %s: %v", addr, err)
+				return
+			}
+
+			this.info("Connected to %s", addr)
+		},
+		TLSHandshakeStart: func() {
+			this.info("TLS handshake started")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				this.info("TLS handshake failed: %v", err)
+				return
+			}
+
+			version, ok := tlsVersionNames[state.Version]
+			if !ok {
+				version = fmt.Sprintf("0x%04x", state.Version)
+			}
+			this.info("TLS handshake done: %s, cipher suite 0x%04x, ALPN %q, server name %q, resumed %v",
+				version, state.CipherSuite, state.NegotiatedProtocol, state.ServerName, state.DidResume)
+
+			for i, cert := range state.PeerCertificates {
+				this.info("Certificate %d: subject %q, issuer %q, expire date %s, DNS names %v",
+					i, cert.Subject.String(), cert.Issuer.String(),
+					cert.NotAfter.UTC().Format(time.RFC3339), cert.DNSNames)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				this.info("Re-using connection to %s (idle for %v)", info.Conn.RemoteAddr(), info.IdleTime)
+			} else {
+				this.info("Connection %s -> %s", info.Conn.LocalAddr(), info.Conn.RemoteAddr())
+			}
+		},
+		WroteHeaderField: func(key string, values []string) {
+			this.lock.Lock()
+			defer this.lock.Unlock()
+
+			for _, value := range values {
+				fmt.Fprintf(&this.header, "%s: %s\r\n", key, value)
+			}
+		},
+		WroteHeaders: func() {
+			this.lock.Lock()
+			this.header.WriteString("\r\n")
+			header := append([]byte(nil), this.header.Bytes()...)
+			this.header.Reset()
+			this.lock.Unlock()
+
+			this.dump("=>", "Send header", header)
+		},
+		Got100Continue: func() {
+			this.info("Got 100 Continue")
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err != nil {
+				this.info("Failed to send the request: %v", info.Err)
+			}
+		},
+	}
+}
+
+// A body traces data as it's read.
+type traceReader struct {
+	io.ReadCloser
+	tracer    *tracer
+	direction string
+	kind      string
+}
+
+func (this *traceReader) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+	this.tracer.dump(this.direction, this.kind, p[:n])
+
+	return n, err
+}
+
+// A RoundTripper traces requests.
+type traceTransport struct {
+	transport http.RoundTripper
+	tracer    *tracer
+}
+
+// Implement http.RoundTripper.
+func (this *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tracer := this.tracer
+	if req.Response != nil {
+		tracer.info("Redirected(%d), issue another request to %s", req.Response.StatusCode, req.URL)
+	}
+
+	// the request line is not reported by httptrace, header fields are
+	tracer.lock.Lock()
+	tracer.header.Reset()
+	fmt.Fprintf(&tracer.header, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	tracer.lock.Unlock()
+
+	req = req.Clone(httptrace.WithClientTrace(req.Context(), tracer.clientTrace()))
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &traceReader{req.Body, tracer, "=>", "Send data"}
+	}
+
+	res, err := this.transport.RoundTrip(req)
+	if err != nil {
+		tracer.info("Request failed: %v", err)
+		return nil, err
+	}
+
+	header := &bytes.Buffer{}
+	fmt.Fprintf(header, "%s %s\r\n", res.Proto, res.Status)
+	names := make([]string, 0, len(res.Header))
+	for name := range res.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range res.Header[name] {
+			fmt.Fprintf(header, "%s: %s\r\n", name, value)
+		}
+	}
+	header.WriteString("\r\n")
+	tracer.dump("<=", "Recv header", header.Bytes())
+
+	res.Body = &traceReader{res.Body, tracer, "<=", "Recv data"}
+
+	return res, nil
+}
+
+// Prepare the tracer with OPT_TRACE and OPT_TRACE_ASCII.
+func prepareTracer(options map[int]interface{}) (*tracer, error) {
+	w_, ok := options[OPT_TRACE]
+	if !ok || w_ == nil {
+		return nil, nil
+	}
+
+	w, ok := w_.(io.Writer)
+	if !ok {
+		return nil, fmt.Errorf("OPT_TRACE must be io.Writer")
+	}
+
+	var ascii bool
+	if ascii_, ok := options[OPT_TRACE_ASCII]; ok {
+		if ascii, ok = ascii_.(bool); !ok {
+			return nil, fmt.Errorf("OPT_TRACE_ASCII must be bool")
+		}
+	}
+
+	return &tracer{
+		w:     w,
+		ascii: ascii,
+	}, nil
+}
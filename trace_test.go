@@ -0,0 +1,106 @@
+// Copyright 2014-2019 Liu Dong <ddliuhb@gmail.com>.
+// Licensed under the MIT license.
+
+package httpclient
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", 302)
+			return
+		}
+		w.Header().Set("X-Test", "yes")
+		w.Write([]byte("response body"))
+	}))
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	res, err := NewHttpClient().
+		WithOption(OPT_UNSAFE_TLS, true).
+		WithOption(OPT_TRACE, buf).
+		WithOption(OPT_TRACE_ASCII, true).
+		Post(server.URL+"/redirect", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ReadAll()
+
+	trace := buf.String()
+	for _, expected := range []string{
+		"== Info: Connected to " + server.Listener.Addr().String(),
+		"== Info: TLS handshake done: TLS 1.3",
+		"== Info: Certificate 0: subject",
+		"=> Send header",
+		"0000: POST /redirect HTTP/1.1\n",
+		": Content-Type: application/x-www-form-urlencoded\n",
+		"=> Send data, 3 bytes (0x3)\n0000: a=b\n",
+		"<= Recv header",
+		": HTTP/1.1 302 Found\n",
+		"== Info: Redirected(302), issue another request to " + server.URL + "/target",
+		"== Info: Re-using connection",
+		"0000: GET /target HTTP/1.1\n",
+		": X-Test: yes\n",
+		"<= Recv data, 13 bytes (0xd)\n0000: response body\n",
+	} {
+		if !strings.Contains(trace, expected) {
+			t.Errorf("trace should contain %q:\n%s", expected, trace)
+		}
+	}
+}
+
+func TestTraceHex(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := &tracer{
+		w: buf,
+	}
+	tracer.dump("=>", "Send data", []byte("0123456789abcdef\r\nxyz"))
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasSuffix(lines[0], " => Send data, 21 bytes (0x15)") {
+		t.Error("wrong header:", lines[0])
+	}
+	if lines[1] != "0000: 30 31 32 33 34 35 36 37 38 39 61 62 63 64 65 66 0123456789abcdef" {
+		t.Error("wrong line:", lines[1])
+	}
+	if lines[2] != "0010: 0d 0a 78 79 7a                                  ..xyz" {
+		t.Error("wrong line:", lines[2])
+	}
+}
+
+// Concurrent requests can share a writer.
+func TestTraceConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("response body"))
+	}))
+	defer server.Close()
+
+	var requests []*BatchRequest
+	for i := 0; i < 16; i++ {
+		requests = append(requests, &BatchRequest{
+			URL: server.URL,
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	c := NewHttpClient().Defaults(Map{
+		OPT_TRACE:       buf,
+		OPT_TRACE_ASCII: true,
+	})
+	for _, result := range c.Batch(requests, &BatchOptions{Workers: 8}) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+
+	if n := strings.Count(buf.String(), "<= Recv data, 13 bytes (0xd)\n0000: response body\n"); n != 16 {
+		t.Errorf("wrong number of traced bodies: %d\n%s", n, buf.String())
+	}
+}